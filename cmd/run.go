@@ -6,11 +6,14 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Seann-Moser/lazer/pkg/controller"
+	"github.com/Seann-Moser/lazer/pkg/io"
 	"github.com/spf13/cobra"
 )
 
@@ -27,8 +30,49 @@ to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		c, err := controller.New(false)
+		config := controller.LoadConfiguration()
+		if err := config.Validate(); err != nil {
+			log.Printf("Invalid hardware configuration:\n%v", err)
+			return
+		}
+		wiring := config.Hardware
+		var hw controller.Hardware
+		if simulate {
+			sim := io.NewSim(wiring.Buttons)
+			hw = controller.Hardware{
+				Servos:       sim.Servos,
+				Pins:         sim.Pins,
+				Laser:        sim.Laser,
+				Lasers:       map[string]io.Laser{},
+				LeftButton:   sim.Left,
+				RightButton:  sim.Right,
+				MotionSensor: sim.Motion,
+			}
+			for _, t := range config.Turrets {
+				hw.Lasers[t.Name] = &io.SimLaser{}
+			}
+			if wiring.Galvo != nil {
+				galvo, err := io.NewSimGalvo(*wiring.Galvo, wiring.PanChannel, wiring.TiltChannel, sim.Servos)
+				if err != nil {
+					log.Printf("Invalid galvo configuration: %v", err)
+					return
+				}
+				defer galvo.Close()
+				hw.Servos = galvo
+			}
+			registerSimHandlers(sim, wiring.PanChannel, wiring.TiltChannel)
+			go readSimInput(sim)
+		} else {
+			var closeHardware func()
+			hw, closeHardware = realHardware(config)
+			defer closeHardware()
+		}
+		for name, err := range hw.Faults {
+			log.Printf("Running without %s: %v", name, err)
+		}
+		c, err := controller.New(config, hw)
 		if err != nil {
+			log.Printf("Error creating controller: %v", err)
 			return
 		}
 		defer c.Close()
@@ -43,6 +87,90 @@ to quickly create a Cobra application.`,
 	},
 }
 
+var simulate bool
+
+// realHardware opens the GPIO chip and PCA9685. Anything that fails is
+// left out of the returned Hardware and recorded in its Faults so the web
+// server and scheduler still come up. The returned func releases whatever
+// was opened.
+func realHardware(config controller.Configuration) (controller.Hardware, func()) {
+	wiring := config.Hardware
+	hw := controller.Hardware{Faults: map[string]error{}}
+	client, err := io.New(wiring.Chip, wiring.PCA9685)
+	if err != nil {
+		hw.Faults[io.SubsystemGPIO] = err
+		return hw, func() {}
+	}
+	hw.Servos = client
+	hw.Pins = client
+	if err := client.SetIdle(wiring.Idle); err != nil {
+		hw.Faults["idle"] = err
+	}
+	if b, err := client.WatchButton(wiring.LeftButton.Line, wiring.LeftButton.Pull, wiring.Buttons); err != nil {
+		hw.Faults["leftButton"] = err
+	} else {
+		hw.LeftButton = b
+	}
+	if b, err := client.WatchButton(wiring.RightButton.Line, wiring.RightButton.Pull, wiring.Buttons); err != nil {
+		hw.Faults["rightButton"] = err
+	} else {
+		hw.RightButton = b
+	}
+	if wiring.MotionSensorLine >= 0 {
+		if s, err := client.WatchMotionSensor(wiring.MotionSensorLine); err != nil {
+			hw.Faults["motionSensor"] = err
+		} else {
+			hw.MotionSensor = s
+		}
+	}
+	if wiring.Laser.Channel >= 0 {
+		hw.Laser = client.PWMLaser(wiring.Laser.Channel)
+	}
+	hw.Lasers = map[string]io.Laser{}
+	for _, t := range config.Turrets {
+		if t.Laser.Channel >= 0 {
+			hw.Lasers[t.Name] = client.PWMLaser(t.Laser.Channel)
+		}
+	}
+	closers := []func(){client.Close}
+	if len(wiring.Steppers) > 0 {
+		axes := map[int]*io.Stepper{}
+		failed := map[int]error{}
+		for ch, sc := range wiring.Steppers {
+			s, err := client.Stepper(sc)
+			if err == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				err = s.Home(ctx)
+				cancel()
+			}
+			if err != nil {
+				hw.Faults[fmt.Sprintf("%s%d", io.SubsystemStepper, ch)] = err
+				failed[ch] = err
+				continue
+			}
+			axes[ch] = s
+		}
+		steppers := io.NewStepperDriver(axes, failed, client)
+		hw.Servos = steppers
+		closers = append(closers, steppers.Close)
+	}
+	if wiring.Galvo != nil {
+		galvo, err := io.OpenGalvo(*wiring.Galvo, wiring.PanChannel, wiring.TiltChannel, hw.Servos)
+		if err != nil {
+			hw.Faults[io.SubsystemGalvo] = err
+		} else {
+			hw.Servos = galvo
+			closers = append(closers, galvo.Close)
+		}
+	}
+	return hw, func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+}
+
 func init() {
+	runCmd.Flags().BoolVar(&simulate, "sim", false, "run against simulated servos, laser and buttons instead of GPIO/I2C")
 	rootCmd.AddCommand(runCmd)
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/signal"
 	"sync"
@@ -25,8 +26,9 @@ to quickly create a Cobra application.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		c, err := controller.New(true)
+		c, err := controller.New(controller.LoadConfiguration(), controller.Hardware{})
 		if err != nil {
+			log.Printf("Error creating controller: %v", err)
 			return
 		}
 		defer c.Close()
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Seann-Moser/lazer/pkg/io"
+)
+
+const defaultSimPress = 200 * time.Millisecond
+
+// pressSimButton presses a simulated button or triggers the motion sensor
+// by name. An empty duration is a short click.
+func pressSimButton(sim *io.Sim, name, duration string) error {
+	b, err := sim.Input(strings.ToLower(name))
+	if err != nil {
+		return err
+	}
+	d := defaultSimPress
+	if duration != "" {
+		d, err = time.ParseDuration(duration)
+		if err != nil {
+			return err
+		}
+	}
+	b.Press(d)
+	return nil
+}
+
+// readSimInput reads button presses from stdin, one per line, e.g. "r",
+// "left 3s", "motion 10s".
+func readSimInput(sim *io.Sim) {
+	fmt.Println("sim: type \"left|right [duration]\" to press a button or \"motion [duration]\" to trigger the motion sensor")
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var duration string
+		if len(fields) > 1 {
+			duration = fields[1]
+		}
+		if err := pressSimButton(sim, fields[0], duration); err != nil {
+			fmt.Printf("sim: %s\n", err)
+		}
+	}
+}
+
+// registerSimHandlers exposes the simulator on the default mux, next to the
+// controller's own endpoints.
+func registerSimHandlers(sim *io.Sim, motorX, motorY int) {
+	http.HandleFunc("/api/sim/press", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if err := pressSimButton(sim, q.Get("button"), q.Get("duration")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	http.HandleFunc("/api/sim/state", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"x":      sim.Servos.Angle(motorX),
+			"y":      sim.Servos.Angle(motorY),
+			"xPulse": sim.Servos.Pulse(motorX),
+			"yPulse": sim.Servos.Pulse(motorY),
+			"laser":  sim.Laser.Brightness(),
+		})
+	})
+}
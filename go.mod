@@ -8,6 +8,7 @@ require (
 	gobot.io/x/gobot v1.16.0
 	periph.io/x/conn/v3 v3.7.2
 	periph.io/x/devices/v3 v3.7.4
+	periph.io/x/host/v3 v3.8.5
 )
 
 require (
@@ -20,6 +21,5 @@ require (
 	github.com/sigurn/utils v0.0.0-20190728110027-e1fefb11a144 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
 	golang.org/x/sys v0.29.0 // indirect
-	periph.io/x/host/v3 v3.8.5 // indirect
 	periph.io/x/periph v3.6.2+incompatible // indirect
 )
@@ -0,0 +1,375 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+
+	"github.com/Seann-Moser/lazer/pkg/io"
+)
+
+// jogSteps are the step sizes a long press on the right button cycles
+// through while recording a play area, in degrees.
+var jogSteps = []float64{1, 5, 10}
+
+// closeDistance is how near the first corner, in degrees, a new corner has
+// to be to close the polygon instead.
+const closeDistance = 3
+
+// randomAreaXY picks a target evenly inside the turret's play area: on the
+// floor when it is calibrated, in servo angles otherwise.
+func (t *Turret) randomAreaXY() (float64, float64, bool) {
+	t.c.areaMu.Lock()
+	area := *t.playArea
+	t.c.areaMu.Unlock()
+	if len(area) == 0 {
+		return 0, 0, false
+	}
+	f := t.floor
+	for _, p := range area {
+		for _, v := range p {
+			if f != nil && f.toFloor.w(v.Pan, v.Tilt) <= 0 {
+				f = nil
+			}
+		}
+	}
+	var tris [][3][2]float64
+	for _, p := range area {
+		pts := make([][2]float64, len(p))
+		for i, v := range p {
+			pts[i] = [2]float64{v.Pan, v.Tilt}
+			if f != nil {
+				pts[i][0], pts[i][1] = f.toFloor.apply(v.Pan, v.Tilt)
+			}
+		}
+		tris = append(tris, triangulate(pts)...)
+	}
+	total := 0.0
+	areas := make([]float64, len(tris))
+	for i, tri := range tris {
+		areas[i] = math.Abs(cross(tri[0], tri[1], tri[2])) / 2
+		total += areas[i]
+	}
+	if total == 0 {
+		return 0, 0, false
+	}
+	r := rand.Float64() * total
+	tri := tris[len(tris)-1]
+	for i, a := range areas {
+		if r < a {
+			tri = tris[i]
+			break
+		}
+		r -= a
+	}
+	u, v := rand.Float64(), rand.Float64()
+	if u+v > 1 {
+		u, v = 1-u, 1-v
+	}
+	x := tri[0][0] + u*(tri[1][0]-tri[0][0]) + v*(tri[2][0]-tri[0][0])
+	y := tri[0][1] + u*(tri[1][1]-tri[0][1]) + v*(tri[2][1]-tri[0][1])
+	if f != nil {
+		x, y = f.toAngles.apply(x, y)
+	}
+	return math.Max(0, math.Min(180, x)), math.Max(0, math.Min(180, y)), true
+}
+
+// cross is twice the signed area of the triangle abc, positive when it
+// turns counterclockwise.
+func cross(a, b, c [2]float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// triangulate splits a simple polygon into triangles by clipping ears, so
+// concave shapes like an L are covered exactly.
+func triangulate(pts [][2]float64) [][3][2]float64 {
+	signed := 0.0
+	for i := range pts {
+		j := (i + 1) % len(pts)
+		signed += pts[i][0]*pts[j][1] - pts[j][0]*pts[i][1]
+	}
+	idx := make([]int, len(pts))
+	for i := range idx {
+		idx[i] = i
+	}
+	if signed < 0 {
+		for i, j := 0, len(idx)-1; i < j; i, j = i+1, j-1 {
+			idx[i], idx[j] = idx[j], idx[i]
+		}
+	}
+	var tris [][3][2]float64
+	for len(idx) > 3 {
+		clipped := false
+		for i := range idx {
+			a, b, c := pts[idx[(i+len(idx)-1)%len(idx)]], pts[idx[i]], pts[idx[(i+1)%len(idx)]]
+			if cross(a, b, c) <= 0 {
+				continue
+			}
+			ear := true
+			for _, k := range idx {
+				p := pts[k]
+				if p == a || p == b || p == c {
+					continue
+				}
+				if cross(a, b, p) >= 0 && cross(b, c, p) >= 0 && cross(c, a, p) >= 0 {
+					ear = false
+					break
+				}
+			}
+			if !ear {
+				continue
+			}
+			tris = append(tris, [3][2]float64{a, b, c})
+			idx = append(idx[:i], idx[i+1:]...)
+			clipped = true
+			break
+		}
+		if !clipped {
+			// Self-intersecting; fan out what is left rather than loop.
+			break
+		}
+	}
+	for i := 1; i+1 < len(idx); i++ {
+		tris = append(tris, [3][2]float64{pts[idx[0]], pts[idx[i]], pts[idx[i+1]]})
+	}
+	return tris
+}
+
+// jog moves the turret by (dx, dy) degrees from where it was sent last.
+func (t *Turret) jog(dx, dy float64) error {
+	x, y := t.c.Servos.GetXY(t.motorX, t.motorY)
+	return t.aim(x+dx, y+dy)
+}
+
+// areaRecorder collects play area polygons while the laser is jogged to
+// each corner.
+type areaRecorder struct {
+	turret   *Turret
+	polygons []Polygon // closed ones
+	current  Polygon
+	axis     int // 0 jogs pan, 1 tilt
+	step     int // index into jogSteps
+}
+
+// vertex records the dot's position as a corner, closing the polygon when
+// it is back near its first corner.
+func (r *areaRecorder) vertex() {
+	x, y := r.turret.c.Servos.GetXY(r.turret.motorX, r.turret.motorY)
+	if len(r.current) >= 3 && math.Hypot(x-r.current[0].Pan, y-r.current[0].Tilt) <= closeDistance {
+		r.close()
+		return
+	}
+	r.current = append(r.current, Vertex{Pan: x, Tilt: y})
+}
+
+// close ends the current polygon, dropping it if it has no area yet.
+func (r *areaRecorder) close() {
+	if r.current.Validate() == nil {
+		r.polygons = append(r.polygons, r.current)
+	}
+	r.current = nil
+}
+
+// holding reports whether the turret is held still for calibration or
+// recording instead of playing.
+func (t *Turret) holding() bool {
+	t.c.areaMu.Lock()
+	defer t.c.areaMu.Unlock()
+	return t.calibration != nil || (t.c.recorder != nil && t.c.recorder.turret == t)
+}
+
+// recording reports whether a play area is being recorded.
+func (c *Controller) recording() bool {
+	c.areaMu.Lock()
+	defer c.areaMu.Unlock()
+	return c.recorder != nil
+}
+
+// startRecording begins recording the play area of t, replacing any other
+// recording.
+func (c *Controller) startRecording(t *Turret) {
+	c.areaMu.Lock()
+	defer c.areaMu.Unlock()
+	c.recorder = &areaRecorder{turret: t}
+}
+
+// finishRecording closes the current polygon and saves the recorded ones as
+// the turret's play area.
+func (c *Controller) finishRecording() error {
+	c.areaMu.Lock()
+	defer c.areaMu.Unlock()
+	r := c.recorder
+	if r == nil {
+		return errors.New("not recording")
+	}
+	r.close()
+	if len(r.polygons) == 0 {
+		return errors.New("no polygon with at least 3 corners recorded")
+	}
+	*r.turret.playArea = r.polygons
+	c.recorder = nil
+	c.saveConfig()
+	return nil
+}
+
+// recordButton drives the play area recorder from the buttons. Clicks jog
+// the dot along the current axis, right forward and left back. A right
+// double click switches axis and a right long press cycles the step size.
+// A left double click records a corner and a left long press saves.
+func (c *Controller) recordButton(b io.ButtonEvent, left bool) {
+	c.areaMu.Lock()
+	r := c.recorder
+	if r == nil {
+		c.areaMu.Unlock()
+		return
+	}
+	t, axis, d := r.turret, r.axis, jogSteps[r.step]
+	switch {
+	case b.Gesture == io.DoubleClick && left:
+		r.vertex()
+	case b.Gesture == io.DoubleClick:
+		r.axis = 1 - r.axis
+	case b.Gesture == io.LongPress && !left:
+		r.step = (r.step + 1) % len(jogSteps)
+	}
+	c.areaMu.Unlock()
+
+	var err error
+	switch {
+	case b.Gesture == io.Click:
+		if left {
+			d = -d
+		}
+		if axis == 0 {
+			err = t.jog(d, 0)
+		} else {
+			err = t.jog(0, d)
+		}
+	case b.Gesture == io.LongPress && left:
+		err = c.finishRecording()
+		if err == nil {
+			log.Printf("%s play area saved", t.Name)
+		}
+	}
+	if err != nil {
+		log.Printf("recording play area: %s", err)
+	}
+}
+
+type areaStatus struct {
+	Recording bool      `json:"recording"`
+	PlayArea  []Polygon `json:"playArea"`
+	Current   Polygon   `json:"current,omitempty"`
+	Axis      string    `json:"axis,omitempty"`
+	Step      float64   `json:"step,omitempty"`
+}
+
+func (c *Controller) areaStatus(t *Turret) areaStatus {
+	c.areaMu.Lock()
+	defer c.areaMu.Unlock()
+	s := areaStatus{PlayArea: *t.playArea}
+	if r := c.recorder; r != nil && r.turret == t {
+		s.Recording = true
+		s.PlayArea = r.polygons
+		s.Current = r.current
+		s.Axis = [2]string{"pan", "tilt"}[r.axis]
+		s.Step = jogSteps[r.step]
+	}
+	return s
+}
+
+// handlePlayArea reports a turret's play area on GET. POST records a new
+// one: "start", move the dot with "jog" by dPan/dTilt or "aim" at
+// pan/tilt, add the corner with "vertex", "close" a polygon to begin the
+// next, then "finish" to save or "cancel". "clear" goes back to the
+// rectangle of the limits.
+func (c *Controller) handlePlayArea(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		t := c.Turret(r.URL.Query().Get("turret"))
+		if t == nil {
+			http.Error(w, "unknown turret: "+r.URL.Query().Get("turret"), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(c.areaStatus(t))
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Turret string  `json:"turret"`
+		Action string  `json:"action"`
+		Pan    float64 `json:"pan"`
+		Tilt   float64 `json:"tilt"`
+		DPan   float64 `json:"dPan"`
+		DTilt  float64 `json:"dTilt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := c.Turret(req.Turret)
+	if t == nil {
+		http.Error(w, "unknown turret: "+req.Turret, http.StatusNotFound)
+		return
+	}
+	if c.Servos == nil {
+		http.Error(w, "no servo driver", http.StatusServiceUnavailable)
+		return
+	}
+	c.areaMu.Lock()
+	rec := c.recorder
+	c.areaMu.Unlock()
+	switch req.Action {
+	case "start", "clear":
+	default:
+		if rec == nil || rec.turret != t {
+			http.Error(w, fmt.Sprintf("not recording %s, start first", t.Name), http.StatusConflict)
+			return
+		}
+	}
+	var err error
+	switch req.Action {
+	case "start":
+		c.startRecording(t)
+	case "jog":
+		err = t.jog(req.DPan, req.DTilt)
+	case "aim":
+		err = t.aim(req.Pan, req.Tilt)
+	case "vertex":
+		c.areaMu.Lock()
+		rec.vertex()
+		c.areaMu.Unlock()
+	case "close":
+		c.areaMu.Lock()
+		rec.close()
+		c.areaMu.Unlock()
+	case "finish":
+		if err := c.finishRecording(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case "cancel":
+		c.areaMu.Lock()
+		c.recorder = nil
+		c.areaMu.Unlock()
+	case "clear":
+		c.areaMu.Lock()
+		*t.playArea = nil
+		c.areaMu.Unlock()
+		c.saveConfig()
+	default:
+		http.Error(w, "unknown action: "+req.Action, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(c.areaStatus(t))
+}
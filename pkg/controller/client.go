@@ -5,14 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math"
 	"math/rand"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/Seann-Moser/lazer/pkg/io"
-	"github.com/warthog618/go-gpiocdev/device/rpi"
 )
 
 type State int
@@ -25,105 +22,147 @@ const (
 	Fast
 )
 
-type MoveType int
-
-const (
-	Straight MoveType = iota
-	Curve
-	Bounce
-	BackAndForth
-	Jagged
-	Ease
-	ZigZag
-	Spiral
-	Random
-	SmoothStep
-	Wave
-	ShortPause
-	LongPause
-)
-
 type Controller struct {
-	LeftButton  *io.Button
-	RightButton *io.Button
+	LeftButton   io.ButtonSource
+	RightButton  io.ButtonSource
+	MotionSensor io.ButtonSource
 
-	Servos        *io.IO
-	motorX        int
-	motorY        int
+	Servos        io.ServoDriver
+	Pins          io.PinDriver
+	Turrets       []*Turret
 	State         State
 	Configuration Configuration //todo load from file and save
 	configuring   bool
 	configChan    chan bool
-	speed         float64
 	maxActiveTime time.Duration
 	active        time.Time
 	pulsePercent  float64
+	patterns      []namedPattern
+	selectionMu   sync.Mutex // guards Configuration.PatternSelection
+	zoneMu        sync.Mutex // guards Configuration.KeepOut
+	areaMu        sync.Mutex // guards recorder and the turrets' play areas and floor calibrations
+	recorder      *areaRecorder
+	buttonLog     buttonLog
+	// brightnessOverride is set by a schedule entry with its own brightness.
+	brightnessOverride float64
+	setupFaults        map[string]error
+	motionLog          motionLog
 }
-type Configuration struct {
-	MinXAngle float64
-	MinYAngle float64
-	MaxXAngle float64
-	MaxYAngle float64
-	Setting   GeneralSetting
+
+// Hardware is the set of devices the controller drives. Any of them may be
+// nil when the controller only serves the web UI.
+type Hardware struct {
+	Servos io.ServoDriver
+	Pins   io.PinDriver
+	// Laser defaults to switching the configured laser line through Pins.
+	Laser io.Laser
+	// Lasers of additional turrets by name, defaulting to their laser line.
+	Lasers      map[string]io.Laser
+	LeftButton  io.ButtonSource
+	RightButton io.ButtonSource
+	// MotionSensor is an optional PIR sensor that starts play.
+	MotionSensor io.ButtonSource
+	// Faults records hardware that failed to come up. The controller still
+	// runs the web server and scheduler and reports them in /api/status.
+	Faults map[string]error
 }
 
-const configFile = ".lazer.config.json"
+func New(config Configuration, hw Hardware) (*Controller, error) {
+	c := &Controller{
+		LeftButton:    hw.LeftButton,
+		RightButton:   hw.RightButton,
+		MotionSensor:  hw.MotionSensor,
+		Servos:        hw.Servos,
+		Pins:          hw.Pins,
+		State:         0,
+		Configuration: config,
+		configChan:    make(chan bool, 1),
+		maxActiveTime: 30 * time.Minute,
+		pulsePercent:  .90,
+		setupFaults:   hw.Faults,
+	}
+	patterns, err := buildPatterns(config.Patterns)
+	if err != nil {
+		log.Printf("invalid patterns, using the built-in ones: %s", err)
+		patterns, _ = buildPatterns(nil)
+	}
+	c.patterns = patterns
+	c.addTurret(MainTurret, config.Hardware.PanChannel, config.Hardware.TiltChannel,
+		hw.Laser, config.Hardware.Laser, &c.Configuration.Limits, &c.Configuration.PlayArea, &c.Configuration.Floor)
+	for i := range c.Configuration.Turrets {
+		tc := &c.Configuration.Turrets[i]
+		c.addTurret(tc.Name, tc.PanChannel, tc.TiltChannel, hw.Lasers[tc.Name], tc.Laser, &tc.Limits, &tc.PlayArea, &tc.Floor)
+	}
+	for _, tc := range c.Configuration.Turrets {
+		if tc.Mode != ModeChase {
+			continue
+		}
+		follow := tc.Follow
+		if follow == "" {
+			follow = MainTurret
+		}
+		leader := c.Turret(follow)
+		if leader == nil {
+			return nil, fmt.Errorf("turret %s follows unknown turret %s", tc.Name, follow)
+		}
+		c.Turret(tc.Name).leader = leader
+	}
+	c.applyCalibration()
+	return c, nil
+}
 
-func New(server bool) (*Controller, error) {
-	var (
-		err                     error
-		leftButton, rightButton *io.Button
-		client                  *io.IO
-	)
-	if !server {
-		client = io.New("gpiochip0")
-		leftButton, err = client.WatchButton(rpi.GPIO26)
-		if err != nil {
-			log.Printf("Error watching button: %v", err)
-			return nil, err
+func (c *Controller) addTurret(name string, motorX, motorY int, laser io.Laser, wiring LaserWiring, limits *Limits, area *[]Polygon, floor *FloorCalibration) {
+	if laser == nil && c.Pins != nil {
+		laser = io.NewPinLaser(c.Pins, wiring.Line, wiring.ActiveLow)
+	}
+	c.Turrets = append(c.Turrets, newTurret(c, name, motorX, motorY, laser, limits, area, floor))
+}
+
+// Turret looks up a turret by name, returning nil if there is none.
+func (c *Controller) Turret(name string) *Turret {
+	for _, t := range c.Turrets {
+		if t.Name == name {
+			return t
 		}
+	}
+	return nil
+}
 
-		rightButton, err = client.WatchButton(rpi.GPIO25)
-		if err != nil {
-			log.Printf("Error watching button: %v", err)
-			return nil, err
+// applyCalibration fills in defaults for the pan/tilt channels and hands the
+// calibration and motion limits to the servo driver.
+func (c *Controller) applyCalibration() {
+	if c.Configuration.Calibration == nil {
+		c.Configuration.Calibration = map[int]io.Calibration{}
+	}
+	for _, t := range c.Turrets {
+		for _, ch := range []int{t.motorX, t.motorY} {
+			if _, ok := c.Configuration.Calibration[ch]; !ok {
+				c.Configuration.Calibration[ch] = io.DefaultCalibration()
+			}
 		}
 	}
-	config := Configuration{
-		MinXAngle: 0,
-		MinYAngle: 0,
-		MaxXAngle: 180,
-		MaxYAngle: 180,
+	if c.Servos == nil {
+		return
 	}
-	data, err := os.ReadFile(configFile)
-	if err != nil {
-		log.Printf("failed loading in config file %s\n", err.Error())
+	for ch, cal := range c.Configuration.Calibration {
+		if err := c.Servos.SetCalibration(ch, cal); err != nil {
+			log.Printf("invalid servo calibration, using defaults: %s", err)
+		}
 	}
-	if data != nil {
-		err = json.Unmarshal(data, &config)
-		if err != nil {
-			log.Printf("failed loading config file")
+	for ch, limits := range c.Configuration.Motion {
+		if err := c.Servos.SetMotionLimits(ch, limits); err != nil {
+			log.Printf("invalid servo motion limits, using defaults: %s", err)
 		}
 	}
-
-	return &Controller{
-		LeftButton:    leftButton,
-		RightButton:   rightButton,
-		Servos:        client,
-		motorX:        1,
-		motorY:        0,
-		State:         0,
-		Configuration: config,
-		configChan:    make(chan bool, 1),
-		speed:         0,
-		maxActiveTime: 30 * time.Minute,
-		pulsePercent:  .90,
-	}, nil
 }
 
+// Close parks the servos and turns the lasers off. The hardware itself is
+// owned and closed by the caller.
 func (c *Controller) Close() {
+	for _, t := range c.Turrets {
+		t.setLaser(false)
+	}
 	if c.Servos != nil {
-		defer c.Servos.Close()
 		c.Servos.Reset()
 	}
 }
@@ -133,74 +172,46 @@ func (c *Controller) Run(ctx context.Context) {
 	go func() {
 		c.StartServer(ctx)
 	}()
+	// A missing button leaves its channel nil so it never fires.
+	var left, right, motion <-chan io.ButtonEvent
+	if c.LeftButton != nil {
+		sub := c.LeftButton.Subscribe()
+		defer sub.Close()
+		left = sub.Events()
+	}
+	if c.RightButton != nil {
+		sub := c.RightButton.Subscribe()
+		defer sub.Close()
+		right = sub.Events()
+	}
+	if c.MotionSensor != nil {
+		sub := c.MotionSensor.Subscribe()
+		defer sub.Close()
+		motion = sub.Events()
+	}
 	wg.Go(func() {
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case b := <-c.LeftButton.Event:
-				if c.configuring {
-					select {
-					case c.configChan <- true:
-					default:
-					}
-				}
-				if b.Duration > 2*time.Second {
-					fmt.Printf("starting")
-					go c.ChangeState(ctx, Configuring)
-				} else {
-					c.ChangeState(ctx, Off)
-				}
-			case b := <-c.RightButton.Event:
-				select {
-				case c.configChan <- true:
-				default:
-				}
-				if b.Duration > 2*time.Second {
-					c.State = Off
-					continue
-				}
-				_ = c.Servos.SetPinState(23, 1)
-				c.active = time.Now()
-				if c.State <= Configuring {
-					c.State = Slow
-				} else if c.State < Fast {
-					c.State = State(int(c.State) + 1)
-				} else {
-					c.State = Off
-				}
-				c.ChangeState(ctx, c.State)
+			case b := <-left:
+				c.handleLeftButton(ctx, b)
+			case b := <-right:
+				c.handleRightButton(ctx, b)
+			case b := <-motion:
+				c.handleMotion(ctx, b)
 			}
 		}
 	})
-	wg.Go(func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				if c.State <= Configuring {
-					_ = c.Servos.SetPinState(23, 0)
-					time.Sleep(1 * time.Second)
-					continue
-				}
-				if rand.Float64() > c.pulsePercent {
-					_ = c.Servos.SetPinState(23, 0)
-				} else {
-					_ = c.Servos.SetPinState(23, 1)
-				}
-				x, y := c.getRandomXY()
-				t := getRandomMoveType()
-				fmt.Printf("x: %d y:%d MovementType:%d\n", x, y, t)
-				err := c.moveTo(ctx, x, y, t)
-				if err != nil {
-					log.Printf("failed to  move to point")
-					continue
-				}
-
-			}
+	if c.Servos == nil {
+		log.Printf("no servo driver, turrets stay parked")
+	} else {
+		for _, t := range c.Turrets {
+			wg.Go(func() {
+				t.run(ctx)
+			})
 		}
-	})
+	}
 	wg.Go(func() {
 		ticker := time.NewTicker(time.Second)
 		for {
@@ -208,7 +219,7 @@ func (c *Controller) Run(ctx context.Context) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if c.State <= Configuring {
+				if !c.playing() {
 					continue
 				}
 				if time.Since(c.active) > c.maxActiveTime {
@@ -234,6 +245,7 @@ func (c *Controller) Run(ctx context.Context) {
 				for _, schedule := range scheduleList {
 					if isNowInSchedule(now, schedule) {
 						c.active = time.Now()
+						c.brightnessOverride = schedule.Brightness
 						c.ChangeState(ctx, schedule.State)
 						break // Only apply first matching schedule
 					}
@@ -243,10 +255,114 @@ func (c *Controller) Run(ctx context.Context) {
 	})
 	wg.Wait()
 }
+
+// playing reports whether any turret is in a play state.
+func (c *Controller) playing() bool {
+	for _, t := range c.Turrets {
+		if t.State > Configuring {
+			return true
+		}
+	}
+	return false
+}
+
+// signalConfig tells a running Configure that a button was pressed.
+func (c *Controller) signalConfig() {
+	select {
+	case c.configChan <- true:
+	default:
+	}
+}
+
+// handleLeftButton: any short press, single or double, turns the laser
+// off and long press starts configuration. While recording a play area, the buttons drive the
+// recorder instead.
+func (c *Controller) handleLeftButton(ctx context.Context, b io.ButtonEvent) {
+	if c.recording() {
+		c.recordButton(b, true)
+		return
+	}
+	switch b.Gesture {
+	case io.Press:
+		if c.configuring {
+			c.signalConfig()
+		}
+	case io.Click, io.DoubleClick:
+		c.ChangeState(ctx, Off)
+	case io.LongPress:
+		fmt.Printf("starting")
+		go c.ChangeState(ctx, Configuring)
+	}
+}
+
+// handleRightButton: click steps through the play states, double click
+// jumps to a random one and long press turns the laser off. Holding on
+// past the long press while the main turret plays starts recording its
+// play area.
+func (c *Controller) handleRightButton(ctx context.Context, b io.ButtonEvent) {
+	if c.recording() {
+		c.recordButton(b, false)
+		return
+	}
+	switch b.Gesture {
+	case io.Press:
+		c.signalConfig()
+	case io.Click:
+		c.brightnessOverride = 0
+		c.active = time.Now()
+		if c.State <= Configuring {
+			c.State = Slow
+		} else if c.State < Fast {
+			c.State = State(int(c.State) + 1)
+		} else {
+			c.State = Off
+		}
+		c.ChangeState(ctx, c.State)
+	case io.DoubleClick:
+		c.brightnessOverride = 0
+		c.active = time.Now()
+		c.ChangeState(ctx, Slow+State(rand.Intn(int(Fast-Slow)+1)))
+	case io.LongPress:
+		c.State = Off
+	case io.HoldRepeat:
+		if t := c.Turret(MainTurret); !c.configuring && c.Servos != nil && t.State > Configuring {
+			c.startRecording(t)
+		}
+	}
+}
+
+func (s State) String() string {
+	switch s {
+	case Off:
+		return "Off"
+	case Configuring:
+		return "Configuring"
+	case Slow:
+		return "Slow"
+	case Medium:
+		return "Medium"
+	case Fast:
+		return "Fast"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// MarshalJSON writes the state by name, so saved configs load back.
+func (s State) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
 func (s *State) UnmarshalJSON(b []byte) error {
 	var str string
 	if err := json.Unmarshal(b, &str); err != nil {
-		return err
+		// Configs saved before states were written by name hold the
+		// numeric value.
+		var n int
+		if json.Unmarshal(b, &n) != nil || n < int(Off) || n > int(Fast) {
+			return err
+		}
+		*s = State(n)
+		return nil
 	}
 
 	switch str {
@@ -285,159 +401,22 @@ func (c *Controller) ChangeState(ctx context.Context, state State) {
 	if c.configuring {
 		return
 	}
-	switch state {
-	case Off:
-		c.Servos.Reset()
-		_ = c.Servos.SetPinState(23, 0)
-	case Configuring:
+	if state == Configuring {
 		c.configuring = true
+		for _, t := range c.Turrets {
+			t.State = Configuring
+		}
 		c.Configure(ctx)
-	case Slow:
-		c.speed = 0.25
-	case Medium:
-		c.speed = 0.5
-	case Fast:
-		c.speed = 1
-	}
-
-	c.State = state
-}
-
-func (c *Controller) getRandomXY() (uint8, uint8) {
-	rand.Seed(time.Now().UnixNano())
-	// Generate random X within configured range
-	x := c.Configuration.MinXAngle + rand.Float64()*(c.Configuration.MaxXAngle-c.Configuration.MinXAngle)
-	y := c.Configuration.MinYAngle + rand.Float64()*(c.Configuration.MaxYAngle-c.Configuration.MinYAngle)
-	// Clamp to 0–180 just in case, then convert to uint8
-	if x < 0 {
-		x = 0
-	} else if x > 180 {
-		x = 180
-	}
-
-	if y < 0 {
-		y = 0
-	} else if y > 180 {
-		y = 180
-	}
-
-	return uint8(x), uint8(y)
-}
-
-func (c *Controller) moveTo(ctx context.Context, x, y uint8, moveType MoveType) error {
-	currentX, currentY := c.Servos.GetXY(c.motorX, c.motorY)
-
-	dx := float64(int(x) - int(currentX))
-	dy := float64(int(y) - int(currentY))
-
-	// Calculate Euclidean distance
-	distance := math.Sqrt(dx*dx + dy*dy)
-
-	// Define step size (units per step), then calculate steps
-	const stepSize = 2.0 // you can tune this
-	steps := int(math.Ceil(distance / stepSize))
-	if steps < 1 {
-		steps = 1
-	}
-
-	for i := 1; i <= steps; i++ {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			t := float64(i) / float64(steps)
-
-			var xi, yi int
-			switch moveType {
-			case Straight:
-				xi = int(float64(currentX) + dx*t)
-				yi = int(float64(currentY) + dy*t)
-			case Curve:
-				xi = int(float64(currentX) + dx*t)
-				yi = int(float64(currentY) + dy*t + 10*math.Sin(t*math.Pi))
-			case Bounce:
-				bounceT := t + 0.1*math.Sin(3*math.Pi*t)
-				xi = int(float64(currentX) + dx*bounceT)
-				yi = int(float64(currentY) + dy*bounceT)
-			case BackAndForth:
-				bt := t
-				if t < 0.5 {
-					bt = t * 2
-				} else {
-					bt = 1 - ((t - 0.5) * 2)
-				}
-				xi = int(float64(currentX) + dx*bt)
-				yi = int(float64(currentY) + dy*bt)
-			case Jagged:
-				jag := rand.Intn(5) - 2
-				xi = int(float64(currentX)+dx*t) + jag
-				yi = int(float64(currentY)+dy*t) - jag
-			case Ease:
-				easeT := -0.5 * (math.Cos(math.Pi*t) - 1)
-				xi = int(float64(currentX) + dx*easeT)
-				yi = int(float64(currentY) + dy*easeT)
-			case ZigZag:
-				offset := int(5 * math.Sin(10*t*math.Pi))
-				xi = int(float64(currentX) + dx*t)
-				yi = int(float64(currentY)+dy*t) + offset
-			case Spiral:
-				radius := 10.0 * (1 - t)
-				angle := 4 * math.Pi * t
-				xi = int(float64(currentX) + dx*t + radius*math.Cos(angle))
-				yi = int(float64(currentY) + dy*t + radius*math.Sin(angle))
-			case Random:
-				xi = int(float64(currentX)+dx*t) + rand.Intn(7) - 3
-				yi = int(float64(currentY)+dy*t) + rand.Intn(7) - 3
-			case SmoothStep:
-				smoothT := t * t * (3 - 2*t)
-				xi = int(float64(currentX) + dx*smoothT)
-				yi = int(float64(currentY) + dy*smoothT)
-			case Wave:
-				amp := 8.0
-				freq := 4.0
-				xi = int(float64(currentX) + dx*t)
-				yi = int(float64(currentY) + dy*t + amp*math.Sin(freq*t*math.Pi))
-			case ShortPause:
-				time.Sleep(time.Second * time.Duration(1+rand.Intn(5)))
-			case LongPause:
-				time.Sleep(time.Second * time.Duration(5+rand.Intn(30)))
-			default:
-				xi = int(float64(currentX) + dx*t)
-				yi = int(float64(currentY) + dy*t)
-			}
-
-			// Clamp to 0–180
-			if xi < 0 {
-				xi = 0
-			} else if xi > 180 {
-				xi = 180
-			}
-			if yi < 0 {
-				yi = 0
-			} else if yi > 180 {
-				yi = 180
-			}
-			waitDelay, err := c.Servos.SetXY(c.motorX, c.motorY, uint8(xi), uint8(yi))
-			if err != nil {
-				return err
-			}
-
-			// Speed control
-			speed := c.speed * 100
-			if speed < 1 {
-				speed = 1
-			}
-			if speed > 100 {
-				speed = 100
-			}
-			speedMultiplier := 100.0 / float64(speed)
-			delay := time.Duration(float64(waitDelay) * speedMultiplier)
-
-			time.Sleep(time.Millisecond * delay)
+	} else {
+		for _, t := range c.Turrets {
+			t.setState(ctx, state)
+		}
+		if state == Off && c.Servos != nil {
+			c.Servos.Reset()
 		}
 	}
 
-	return nil
+	c.State = state
 }
 
 func (c *Controller) Configure(ctx context.Context) {
@@ -446,18 +425,18 @@ func (c *Controller) Configure(ctx context.Context) {
 		c.configuring = false
 	}()
 	fmt.Printf("Configuring....")
-
-	c.Configuration.MinXAngle, c.Configuration.MaxXAngle = c.motorConfig(ctx, c.motorX)
-	c.Configuration.MinYAngle, c.Configuration.MaxYAngle = c.motorConfig(ctx, c.motorY)
-
-	data, err := json.Marshal(c.Configuration)
-	if err != nil {
-		log.Printf("failed marshalling config file")
+	if c.Servos == nil {
+		log.Printf("no servo driver to configure")
+		return
 	}
-	err = os.WriteFile(configFile, data, 0777)
-	if err != nil {
-		log.Printf("failed saving config file")
+
+	for _, t := range c.Turrets {
+		fmt.Printf("Configuring %s\n", t.Name)
+		t.limits.MinXAngle, t.limits.MaxXAngle = c.motorConfig(ctx, t.motorX)
+		t.limits.MinYAngle, t.limits.MaxYAngle = c.motorConfig(ctx, t.motorY)
 	}
+
+	c.saveConfig()
 	fmt.Printf("Finishing Configuration")
 	c.Servos.Reset()
 }
@@ -465,11 +444,11 @@ func (c *Controller) Configure(ctx context.Context) {
 func (c *Controller) motorConfig(ctx context.Context, pin int) (float64, float64) {
 	start := false
 	var startValue float64
-	c.Servos.SetXY(pin, 0, uint8(0), 0)
+	c.Servos.SetXY(pin, 0, 0, 0)
 	time.Sleep(time.Second)
 	for i := 0; i < 180; i++ { //9-92
-		d, _ := c.Servos.SetXY(pin, -1, uint8(i), 0)
-		time.Sleep(time.Duration(d) * time.Millisecond * 4)
+		d, _ := c.Servos.SetXY(pin, -1, float64(i), 0)
+		time.Sleep(d * 4)
 		select {
 		case <-ctx.Done():
 			return 0, 180
@@ -488,9 +467,3 @@ func (c *Controller) motorConfig(ctx context.Context, pin int) (float64, float64
 	}
 	return startValue, 180
 }
-func getRandomMoveType() MoveType {
-	// Total number of move types (update this if you add more types)
-	const moveTypeCount = int(Wave + 1)
-
-	return MoveType(rand.Intn(moveTypeCount))
-}
@@ -0,0 +1,322 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Seann-Moser/lazer/pkg/io"
+)
+
+const configFile = ".lazer.config.json"
+
+type Configuration struct {
+	Limits
+	Setting GeneralSetting
+	// Calibration holds the pulse calibration for each servo channel.
+	Calibration map[int]io.Calibration
+	// Motion holds the speed and acceleration of each servo channel.
+	Motion   map[int]io.MotionLimits
+	Hardware HardwareConfig
+	Laser    LaserSettings
+	Watchdog WatchdogConfig
+	// MotionTrigger starts play from the motion sensor.
+	MotionTrigger MotionTrigger
+	// Turrets are pan/tilt heads in addition to the main one.
+	Turrets []TurretConfig
+	// Patterns are the movement patterns picked from during play, every
+	// built-in one when empty.
+	Patterns []PatternConfig
+	// PatternSelection weighs the patterns for each play state.
+	PatternSelection PatternSelection
+	// Floor maps the main turret's angles to the floor.
+	Floor FloorCalibration
+	// KeepOut are regions the laser never shines into.
+	KeepOut []KeepOutZone
+	// PlayArea replaces the rectangle of the main turret's limits for
+	// picking targets, for rooms that are not rectangles in servo angles.
+	PlayArea []Polygon
+}
+
+// HardwareConfig describes how the unit is wired.
+type HardwareConfig struct {
+	Chip        string           `json:"chip"` // gpiochip device, e.g. gpiochip0
+	LeftButton  ButtonWiring     `json:"leftButton"`
+	RightButton ButtonWiring     `json:"rightButton"`
+	Laser       LaserWiring      `json:"laser"`
+	PanChannel  int              `json:"panChannel"`  // PCA9685 channel of the X servo
+	TiltChannel int              `json:"tiltChannel"` // PCA9685 channel of the Y servo
+	PCA9685     io.PCA9685Config `json:"pca9685"`
+	Buttons     io.ButtonConfig  `json:"buttons"`
+	Idle        io.IdleConfig    `json:"idle"`
+	// Steppers drive axes through step/dir drivers instead of servos, keyed
+	// by the channel the axis is addressed by, e.g. panChannel.
+	Steppers map[int]io.StepperConfig `json:"steppers"`
+	// Galvo drives the main turret's pan/tilt channels with galvo mirrors
+	// on an I2C DAC instead of servos.
+	Galvo *io.GalvoConfig `json:"galvo,omitempty"`
+	// MotionSensorLine is the GPIO line of a PIR sensor output, -1 when
+	// there is none.
+	MotionSensorLine int `json:"motionSensorLine"`
+}
+
+type ButtonWiring struct {
+	Line int     `json:"line"`
+	Pull io.Pull `json:"pull"`
+}
+
+type LaserWiring struct {
+	Line      int  `json:"line"`
+	ActiveLow bool `json:"activeLow"`
+	// Channel is the PCA9685 channel of a dimmable laser, or -1 to switch
+	// Line on and off.
+	Channel int `json:"channel"`
+}
+
+func DefaultHardwareConfig() HardwareConfig {
+	return HardwareConfig{
+		Chip:        "gpiochip0",
+		LeftButton:  ButtonWiring{Line: 26, Pull: io.PullUp},
+		RightButton: ButtonWiring{Line: 25, Pull: io.PullUp},
+		Laser:       LaserWiring{Line: 23, Channel: -1},
+		PanChannel:  1,
+		TiltChannel: 0,
+		PCA9685:     io.DefaultPCA9685Config(),
+		Buttons:     io.DefaultButtonConfig(),
+		Idle:        io.DefaultIdleConfig(),
+
+		MotionSensorLine: -1,
+	}
+}
+
+// wiring collects conflicts between GPIO lines and PCA9685 channels.
+type wiring struct {
+	errs     []error
+	lines    map[int]string
+	channels map[int]string
+}
+
+func newWiring() *wiring {
+	return &wiring{lines: map[int]string{}, channels: map[int]string{}}
+}
+
+func (w *wiring) line(name string, line int) {
+	if line < 0 {
+		w.errs = append(w.errs, fmt.Errorf("%s: invalid GPIO line %d", name, line))
+		return
+	}
+	if other, ok := w.lines[line]; ok {
+		w.errs = append(w.errs, fmt.Errorf("%s: GPIO line %d already used by %s", name, line, other))
+		return
+	}
+	w.lines[line] = name
+}
+
+func (w *wiring) channel(name string, ch int) {
+	if ch < 0 || ch > 15 {
+		w.errs = append(w.errs, fmt.Errorf("%s: PCA9685 channel %d outside 0-15", name, ch))
+		return
+	}
+	if other, ok := w.channels[ch]; ok {
+		w.errs = append(w.errs, fmt.Errorf("%s: PCA9685 channel %d already used by %s", name, ch, other))
+		return
+	}
+	w.channels[ch] = name
+}
+
+func (w *wiring) laser(name string, l LaserWiring) {
+	if l.Channel >= 0 {
+		w.channel(name+".channel", l.Channel)
+	} else {
+		w.line(name, l.Line)
+	}
+}
+
+// Validate reports every wiring problem at once so a misconfigured unit can
+// be fixed in one pass.
+func (h HardwareConfig) Validate() error {
+	w := newWiring()
+	h.validate(w)
+	return errors.Join(w.errs...)
+}
+
+func (h HardwareConfig) validate(w *wiring) {
+	if h.Chip == "" {
+		w.errs = append(w.errs, errors.New("hardware.chip must be set"))
+	}
+	w.line("leftButton", h.LeftButton.Line)
+	w.line("rightButton", h.RightButton.Line)
+	for name, p := range map[string]io.Pull{"leftButton": h.LeftButton.Pull, "rightButton": h.RightButton.Pull} {
+		if err := p.Validate(); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	w.laser("laser", h.Laser)
+	if h.Idle.PowerLine >= 0 {
+		w.line("idle.powerLine", h.Idle.PowerLine)
+	}
+	if h.MotionSensorLine >= 0 {
+		w.line("motionSensorLine", h.MotionSensorLine)
+	}
+	for ch, s := range h.Steppers {
+		name := fmt.Sprintf("steppers[%d]", ch)
+		if err := s.Validate(); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		w.line(name+".stepLine", s.StepLine)
+		w.line(name+".dirLine", s.DirLine)
+		if s.EnableLine >= 0 {
+			w.line(name+".enableLine", s.EnableLine)
+		}
+		if s.EndstopLine >= 0 {
+			w.line(name+".endstopLine", s.EndstopLine)
+		}
+	}
+	if h.Galvo != nil {
+		if err := h.Galvo.Validate(); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("galvo: %w", err))
+		}
+	}
+	w.channel("panChannel", h.PanChannel)
+	w.channel("tiltChannel", h.TiltChannel)
+
+	switch h.PCA9685.Backend {
+	case io.BackendGobot, io.BackendPeriph, "":
+		if h.PCA9685.Address < 0x03 || h.PCA9685.Address > 0x77 {
+			w.errs = append(w.errs, fmt.Errorf("pca9685: I2C address 0x%02x outside 0x03-0x77", h.PCA9685.Address))
+		}
+	case io.BackendSysfs:
+		if h.PCA9685.SysfsChip < 0 {
+			w.errs = append(w.errs, fmt.Errorf("pca9685: invalid sysfs pwmchip %d", h.PCA9685.SysfsChip))
+		}
+	default:
+		w.errs = append(w.errs, fmt.Errorf("pca9685: unknown backend %q", h.PCA9685.Backend))
+	}
+	if f := h.PCA9685.Frequency; f != 0 && (f < 24 || f > 1526) {
+		w.errs = append(w.errs, fmt.Errorf("pca9685: frequency %vHz outside 24-1526Hz", f))
+	}
+	if err := h.Buttons.Overflow.Validate(); err != nil {
+		w.errs = append(w.errs, fmt.Errorf("buttons: %w", err))
+	}
+}
+
+// Validate checks the hardware section together with the wiring of every
+// additional turret.
+func (c Configuration) Validate() error {
+	w := newWiring()
+	c.Hardware.validate(w)
+	if c.MotionTrigger.Enabled {
+		if err := c.MotionTrigger.Validate(); err != nil {
+			w.errs = append(w.errs, err)
+		}
+	}
+	if _, err := buildPatterns(c.Patterns); err != nil {
+		w.errs = append(w.errs, fmt.Errorf("patterns: %w", err))
+	}
+	if err := c.PatternSelection.Validate(); err != nil {
+		w.errs = append(w.errs, fmt.Errorf("patternSelection: %w", err))
+	}
+	if err := c.Floor.Validate(); err != nil {
+		w.errs = append(w.errs, fmt.Errorf("floor: %w", err))
+	}
+	for i, p := range c.PlayArea {
+		if err := p.Validate(); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("playArea[%d]: %w", i, err))
+		}
+	}
+	names := map[string]bool{MainTurret: true}
+	for i, t := range c.Turrets {
+		prefix := fmt.Sprintf("turrets[%d]", i)
+		if t.Name == "" || names[t.Name] {
+			w.errs = append(w.errs, fmt.Errorf("%s: name %q is empty or already taken", prefix, t.Name))
+		}
+		names[t.Name] = true
+		w.channel(prefix+".panChannel", t.PanChannel)
+		w.channel(prefix+".tiltChannel", t.TiltChannel)
+		w.laser(prefix+".laser", t.Laser)
+		if err := t.Floor.Validate(); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("%s.floor: %w", prefix, err))
+		}
+		for j, p := range t.PlayArea {
+			if err := p.Validate(); err != nil {
+				w.errs = append(w.errs, fmt.Errorf("%s.playArea[%d]: %w", prefix, j, err))
+			}
+		}
+		switch t.Mode {
+		case ModeIndependent, "":
+		case ModeChase:
+			if t.Follow == t.Name {
+				w.errs = append(w.errs, fmt.Errorf("%s: a turret cannot chase itself", prefix))
+			}
+		default:
+			w.errs = append(w.errs, fmt.Errorf("%s: unknown mode %q", prefix, t.Mode))
+		}
+	}
+	zones := map[string]bool{}
+	for i, z := range c.KeepOut {
+		if err := z.Validate(); err != nil {
+			w.errs = append(w.errs, fmt.Errorf("keepOut[%d]: %w", i, err))
+		}
+		if zones[z.Name] {
+			w.errs = append(w.errs, fmt.Errorf("keepOut[%d]: zone %q defined twice", i, z.Name))
+		}
+		zones[z.Name] = true
+		if z.Turret != "" && !names[z.Turret] {
+			w.errs = append(w.errs, fmt.Errorf("keepOut[%d]: unknown turret %q", i, z.Turret))
+		}
+	}
+	for i, t := range c.Turrets {
+		if t.Mode == ModeChase && t.Follow != "" && !names[t.Follow] {
+			w.errs = append(w.errs, fmt.Errorf("turrets[%d]: follows unknown turret %q", i, t.Follow))
+		}
+	}
+	return errors.Join(w.errs...)
+}
+
+// LoadConfiguration reads the config file, falling back to defaults for
+// anything it does not set.
+func LoadConfiguration() Configuration {
+	config := Configuration{
+		Limits: Limits{
+			MinXAngle: 0,
+			MinYAngle: 0,
+			MaxXAngle: 180,
+			MaxYAngle: 180,
+		},
+		Hardware:      DefaultHardwareConfig(),
+		Watchdog:      DefaultWatchdogConfig(),
+		MotionTrigger: DefaultMotionTrigger(),
+
+		PatternSelection: DefaultPatternSelection(),
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		log.Printf("failed loading in config file %s\n", err.Error())
+	}
+	if data != nil {
+		err = json.Unmarshal(data, &config)
+		if err != nil {
+			log.Printf("failed loading config file")
+		}
+	}
+	for i := range config.Turrets {
+		if config.Turrets[i].Limits == (Limits{}) {
+			config.Turrets[i].Limits = Limits{MaxXAngle: 180, MaxYAngle: 180}
+		}
+	}
+	return config
+}
+
+func (c *Controller) saveConfig() {
+	data, err := json.Marshal(c.Configuration)
+	if err != nil {
+		log.Printf("failed marshalling config file")
+	}
+	err = os.WriteFile(configFile, data, 0777)
+	if err != nil {
+		log.Printf("failed saving config file")
+	}
+}
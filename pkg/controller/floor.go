@@ -0,0 +1,379 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+)
+
+// FloorPoint is a spot on the floor the laser was aimed at while
+// calibrating.
+type FloorPoint struct {
+	Pan  float64 `json:"pan"` // servo angles
+	Tilt float64 `json:"tilt"`
+	X    float64 `json:"x"` // floor position in cm
+	Y    float64 `json:"y"`
+}
+
+// FloorCalibration maps servo angles to the floor, so targets are spread
+// evenly over the room and the dot moves at the same speed near and far.
+// It needs at least four points, no three of them in a line.
+type FloorCalibration struct {
+	Points []FloorPoint `json:"points"`
+	// Speed is how fast the dot crosses the floor in Fast play, in cm/s.
+	// Slow and Medium play scale it down. 0 means 100cm/s.
+	Speed float64 `json:"speed"`
+}
+
+func (f FloorCalibration) Validate() error {
+	if f.Speed < 0 {
+		return fmt.Errorf("speed must not be negative, got %g", f.Speed)
+	}
+	if len(f.Points) == 0 {
+		return nil
+	}
+	_, err := fitFloor(f)
+	return err
+}
+
+// floorMap converts between servo angles and floor coordinates.
+type floorMap struct {
+	toFloor  homography
+	toAngles homography
+	scale    float64 // cm per degree around the middle of the points
+	speed    float64 // cm/s in Fast play
+	rms      float64 // fit error over the points in cm
+}
+
+// fitFloor computes the floor mapping of a calibration.
+func fitFloor(f FloorCalibration) (*floorMap, error) {
+	if len(f.Points) < 4 {
+		return nil, fmt.Errorf("need at least 4 floor points, got %d", len(f.Points))
+	}
+	from := make([][2]float64, len(f.Points))
+	to := make([][2]float64, len(f.Points))
+	var cx, cy float64
+	for i, p := range f.Points {
+		from[i] = [2]float64{p.Pan, p.Tilt}
+		to[i] = [2]float64{p.X, p.Y}
+		cx += p.Pan / float64(len(f.Points))
+		cy += p.Tilt / float64(len(f.Points))
+	}
+	h, err := fitHomography(from, to)
+	if err != nil {
+		return nil, err
+	}
+	// Keep w positive on the calibrated side of the horizon.
+	if h.w(cx, cy) < 0 {
+		for i := range h {
+			h[i] = -h[i]
+		}
+	}
+	inv, ok := h.inverse()
+	if !ok {
+		return nil, errors.New("floor points are degenerate")
+	}
+	m := &floorMap{toFloor: h, toAngles: inv, speed: f.Speed}
+	if m.speed == 0 {
+		m.speed = 100
+	}
+	// The area a square degree covers at the middle of the points.
+	x0, y0 := h.apply(cx, cy)
+	x1, y1 := h.apply(cx+1, cy)
+	x2, y2 := h.apply(cx, cy+1)
+	m.scale = math.Sqrt(math.Abs((x1-x0)*(y2-y0) - (x2-x0)*(y1-y0)))
+	if m.scale == 0 || math.IsNaN(m.scale) {
+		return nil, errors.New("floor points are degenerate")
+	}
+	var sum float64
+	for i := range from {
+		x, y := h.apply(from[i][0], from[i][1])
+		sum += (x-to[i][0])*(x-to[i][0]) + (y-to[i][1])*(y-to[i][1])
+	}
+	m.rms = math.Sqrt(sum / float64(len(from)))
+	return m, nil
+}
+
+// randomFloorXY picks a target evenly over the patch of floor inside the
+// turret's limits. It fails without a calibration, or when the limits reach
+// past the horizon where angles no longer land on the floor.
+func (t *Turret) randomFloorXY() (float64, float64, bool) {
+	f := t.floor
+	if f == nil {
+		return 0, 0, false
+	}
+	l := t.limits
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, c := range [][2]float64{
+		{l.MinXAngle, l.MinYAngle}, {l.MaxXAngle, l.MinYAngle},
+		{l.MaxXAngle, l.MaxYAngle}, {l.MinXAngle, l.MaxYAngle},
+	} {
+		if f.toFloor.w(c[0], c[1]) <= 0 {
+			return 0, 0, false
+		}
+		x, y := f.toFloor.apply(c[0], c[1])
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	// The limits map to a quadrilateral on the floor; sample its bounding
+	// box and keep the first point that lands inside.
+	for range 64 {
+		x := minX + rand.Float64()*(maxX-minX)
+		y := minY + rand.Float64()*(maxY-minY)
+		ax, ay := f.toAngles.apply(x, y)
+		if ax >= math.Max(0, l.MinXAngle) && ax <= math.Min(180, l.MaxXAngle) &&
+			ay >= math.Max(0, l.MinYAngle) && ay <= math.Min(180, l.MaxYAngle) {
+			return ax, ay, true
+		}
+	}
+	return 0, 0, false
+}
+
+// floorSession collects floor points while a turret is being calibrated.
+// The turret holds still with its laser on until it ends.
+type floorSession struct {
+	points []FloorPoint
+}
+
+type floorStatus struct {
+	Calibrating bool         `json:"calibrating"`
+	Points      []FloorPoint `json:"points"`
+	Calibrated  bool         `json:"calibrated"`
+	Error       float64      `json:"error,omitempty"` // fit error in cm
+	Scale       float64      `json:"scale,omitempty"` // cm per degree
+}
+
+func (t *Turret) floorStatus() floorStatus {
+	s := floorStatus{Points: t.floorConfig.Points}
+	t.c.areaMu.Lock()
+	if t.calibration != nil {
+		s.Calibrating = true
+		s.Points = t.calibration.points
+	}
+	t.c.areaMu.Unlock()
+	if t.floor != nil {
+		s.Calibrated = true
+		s.Error = t.floor.rms
+		s.Scale = t.floor.scale
+	}
+	return s
+}
+
+// handleFloor reports a turret's floor calibration on GET. POST runs the
+// calibration: "start" it, "aim" the laser at pan/tilt or "jog" it by
+// dPan/dTilt, record the floor
+// position x/y of the spot with "point", then "finish" or "cancel".
+func (c *Controller) handleFloor(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		t := c.Turret(r.URL.Query().Get("turret"))
+		if t == nil {
+			http.Error(w, "unknown turret: "+r.URL.Query().Get("turret"), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(t.floorStatus())
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Turret string  `json:"turret"`
+		Action string  `json:"action"`
+		Pan    float64 `json:"pan"`
+		Tilt   float64 `json:"tilt"`
+		DPan   float64 `json:"dPan"`
+		DTilt  float64 `json:"dTilt"`
+		X      float64 `json:"x"`
+		Y      float64 `json:"y"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := c.Turret(req.Turret)
+	if t == nil {
+		http.Error(w, "unknown turret: "+req.Turret, http.StatusNotFound)
+		return
+	}
+	if c.Servos == nil {
+		http.Error(w, "no servo driver", http.StatusServiceUnavailable)
+		return
+	}
+	c.areaMu.Lock()
+	session := t.calibration
+	c.areaMu.Unlock()
+	if req.Action != "start" && session == nil {
+		http.Error(w, "not calibrating, start first", http.StatusConflict)
+		return
+	}
+	switch req.Action {
+	case "start":
+		c.areaMu.Lock()
+		t.calibration = &floorSession{}
+		c.areaMu.Unlock()
+	case "aim":
+		if err := t.aim(req.Pan, req.Tilt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "jog":
+		if err := t.jog(req.DPan, req.DTilt); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	case "point":
+		pan, tilt := c.Servos.GetXY(t.motorX, t.motorY)
+		c.areaMu.Lock()
+		session.points = append(session.points, FloorPoint{Pan: pan, Tilt: tilt, X: req.X, Y: req.Y})
+		c.areaMu.Unlock()
+	case "finish":
+		floor := *t.floorConfig
+		c.areaMu.Lock()
+		floor.Points = session.points
+		c.areaMu.Unlock()
+		m, err := fitFloor(floor)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*t.floorConfig = floor
+		t.floor = m
+		c.areaMu.Lock()
+		t.calibration = nil
+		c.areaMu.Unlock()
+		c.saveConfig()
+	case "cancel":
+		c.areaMu.Lock()
+		t.calibration = nil
+		c.areaMu.Unlock()
+	default:
+		http.Error(w, "unknown action: "+req.Action, http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(t.floorStatus())
+}
+
+// homography is a 3x3 projective transform in row-major order.
+type homography [9]float64
+
+func (h homography) apply(x, y float64) (float64, float64) {
+	w := h[6]*x + h[7]*y + h[8]
+	return (h[0]*x + h[1]*y + h[2]) / w, (h[3]*x + h[4]*y + h[5]) / w
+}
+
+// w is the projective divisor, which changes sign across the horizon.
+func (h homography) w(x, y float64) float64 {
+	return h[6]*x + h[7]*y + h[8]
+}
+
+func (h homography) mul(o homography) homography {
+	var r homography
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[3*i+j] += h[3*i+k] * o[3*k+j]
+			}
+		}
+	}
+	return r
+}
+
+func (h homography) inverse() (homography, bool) {
+	a, b, c := h[0], h[1], h[2]
+	d, e, f := h[3], h[4], h[5]
+	g, k, l := h[6], h[7], h[8]
+	det := a*(e*l-f*k) - b*(d*l-f*g) + c*(d*k-e*g)
+	if math.Abs(det) < 1e-12 {
+		return homography{}, false
+	}
+	return homography{
+		(e*l - f*k) / det, (c*k - b*l) / det, (b*f - c*e) / det,
+		(f*g - d*l) / det, (a*l - c*g) / det, (c*d - a*f) / det,
+		(d*k - e*g) / det, (b*g - a*k) / det, (a*e - b*d) / det,
+	}, true
+}
+
+// normalizer moves points to their centroid and scales them to an average
+// distance of √2, which keeps the fit well conditioned.
+func normalizer(pts [][2]float64) homography {
+	var cx, cy float64
+	for _, p := range pts {
+		cx += p[0] / float64(len(pts))
+		cy += p[1] / float64(len(pts))
+	}
+	var d float64
+	for _, p := range pts {
+		d += math.Hypot(p[0]-cx, p[1]-cy) / float64(len(pts))
+	}
+	s := 1.0
+	if d > 0 {
+		s = math.Sqrt2 / d
+	}
+	return homography{s, 0, -s * cx, 0, s, -s * cy, 0, 0, 1}
+}
+
+// fitHomography finds the least-squares homography taking from to to.
+func fitHomography(from, to [][2]float64) (homography, error) {
+	tf, tt := normalizer(from), normalizer(to)
+	// Normal equations of the 8 unknowns, with the last entry fixed at 1.
+	var ata [8][9]float64
+	for i := range from {
+		x, y := tf.apply(from[i][0], from[i][1])
+		u, v := tt.apply(to[i][0], to[i][1])
+		rows := [2][9]float64{
+			{x, y, 1, 0, 0, 0, -x * u, -y * u, u},
+			{0, 0, 0, x, y, 1, -x * v, -y * v, v},
+		}
+		for _, r := range rows {
+			for j := 0; j < 8; j++ {
+				for k := 0; k < 9; k++ {
+					ata[j][k] += r[j] * r[k]
+				}
+			}
+		}
+	}
+	sol, ok := solve(ata)
+	if !ok {
+		return homography{}, errors.New("floor points are degenerate, are three of them in a line?")
+	}
+	hn := homography{sol[0], sol[1], sol[2], sol[3], sol[4], sol[5], sol[6], sol[7], 1}
+	ttInv, _ := tt.inverse()
+	return ttInv.mul(hn).mul(tf), nil
+}
+
+// solve runs Gaussian elimination with partial pivoting on an augmented
+// 8x8 system.
+func solve(m [8][9]float64) ([8]float64, bool) {
+	var x [8]float64
+	for col := 0; col < 8; col++ {
+		pivot := col
+		for r := col + 1; r < 8; r++ {
+			if math.Abs(m[r][col]) > math.Abs(m[pivot][col]) {
+				pivot = r
+			}
+		}
+		if math.Abs(m[pivot][col]) < 1e-9 {
+			return x, false
+		}
+		m[col], m[pivot] = m[pivot], m[col]
+		for r := col + 1; r < 8; r++ {
+			f := m[r][col] / m[col][col]
+			for k := col; k < 9; k++ {
+				m[r][k] -= f * m[col][k]
+			}
+		}
+	}
+	for r := 7; r >= 0; r-- {
+		s := m[r][8]
+		for k := r + 1; k < 8; k++ {
+			s -= m[r][k] * x[k]
+		}
+		x[r] = s / m[r][r]
+	}
+	return x, true
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Seann-Moser/lazer/pkg/io"
+)
+
+// LaserSettings controls how bright the laser is in each play state.
+type LaserSettings struct {
+	Brightness map[State]float64 `json:"brightness"` // percent per state, 100 when unset
+	Fade       time.Duration     `json:"fade"`       // ramp time when play starts or stops
+	Effect     io.LaserEffect    `json:"effect"`
+}
+
+// laserLevel is the brightness for the current state, honoring a
+// brightness set by the active schedule.
+func (t *Turret) laserLevel() float64 {
+	if t.c.brightnessOverride > 0 {
+		return t.c.brightnessOverride
+	}
+	if b, ok := t.c.Configuration.Laser.Brightness[t.State]; ok {
+		return b
+	}
+	return 100
+}
+
+// setLaser switches the laser between off and the current state's
+// brightness. It stays off while the dot crosses a keep-out zone.
+func (t *Turret) setLaser(on bool) {
+	t.laserMu.Lock()
+	defer t.laserMu.Unlock()
+	t.laserOn = on
+	t.writeLaser()
+}
+
+// laserRequested reports whether play asked for the laser to be on.
+func (t *Turret) laserRequested() bool {
+	t.laserMu.Lock()
+	defer t.laserMu.Unlock()
+	return t.laserOn
+}
+
+// writeLaser applies laserOn and blanked to the laser. Callers must hold
+// t.laserMu.
+func (t *Turret) writeLaser() {
+	if t.Laser == nil {
+		return
+	}
+	level := 0.0
+	if t.laserOn && !t.blanked {
+		level = t.laserLevel()
+	}
+	if err := t.Laser.SetBrightness(level); err != nil {
+		log.Printf("%s failed setting laser brightness: %s", t.Name, err)
+	}
+}
+
+// fadeLaser ramps the laser to its level for the current state, or off.
+func (t *Turret) fadeLaser(ctx context.Context, on bool) {
+	if t.Laser == nil {
+		return
+	}
+	level := 0.0
+	if on {
+		level = t.laserLevel()
+	}
+	if err := io.Fade(ctx, t.Laser, level, t.c.Configuration.Laser.Fade); err != nil {
+		log.Printf("%s failed fading laser: %s", t.Name, err)
+		t.setLaser(on)
+	}
+}
@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyStats summarizes how long motion steps take to reach the servos.
+type LatencyStats struct {
+	Last    time.Duration `json:"last"`
+	Average time.Duration `json:"average"` // exponentially weighted
+	Max     time.Duration `json:"max"`
+	Steps   uint64        `json:"steps"`
+}
+
+// stepLatency measures the time from issuing a step until the driver has
+// written it.
+type stepLatency struct {
+	mu    sync.Mutex
+	stats LatencyStats
+}
+
+func (l *stepLatency) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := &l.stats
+	s.Last = d
+	if s.Steps == 0 {
+		s.Average = d
+	} else {
+		s.Average += (d - s.Average) / 16
+	}
+	s.Max = max(s.Max, d)
+	s.Steps++
+}
+
+func (l *stepLatency) Stats() LatencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
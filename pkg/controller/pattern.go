@@ -0,0 +1,251 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Pattern shapes the path of one move. Point returns the offset from the
+// start of a move by (dx, dy) at progress t, which runs from 0 to 1.
+type Pattern interface {
+	Point(t, dx, dy float64) (float64, float64)
+}
+
+// PatternFunc adapts a plain function to a Pattern.
+type PatternFunc func(t, dx, dy float64) (float64, float64)
+
+func (f PatternFunc) Point(t, dx, dy float64) (float64, float64) {
+	return f(t, dx, dy)
+}
+
+// Pause is a Pattern that keeps the dot where it is for a while instead of
+// moving it.
+type Pause interface {
+	Pattern
+	Hold() time.Duration
+}
+
+// PatternParams are the tunable numbers of a pattern, such as amplitude or
+// frequency.
+type PatternParams map[string]float64
+
+// PatternFactory builds a pattern from its parameters.
+type PatternFactory func(params PatternParams) (Pattern, error)
+
+// PatternConfig enables a registered pattern for play.
+type PatternConfig struct {
+	Name   string        `json:"name"`
+	Params PatternParams `json:"params,omitempty"`
+}
+
+// Names of the built-in patterns.
+const (
+	PatternStraight     = "straight"
+	PatternCurve        = "curve"
+	PatternBounce       = "bounce"
+	PatternBackAndForth = "backAndForth"
+	PatternJagged       = "jagged"
+	PatternEase         = "ease"
+	PatternZigZag       = "zigZag"
+	PatternSpiral       = "spiral"
+	PatternRandom       = "random"
+	PatternSmoothStep   = "smoothStep"
+	PatternWave         = "wave"
+	PatternShortPause   = "shortPause"
+	PatternLongPause    = "longPause"
+)
+
+var (
+	patternsMu sync.RWMutex
+	patterns   = map[string]PatternFactory{}
+)
+
+// RegisterPattern makes a pattern available by name to the config file. It
+// panics if the name is taken, like registering two database drivers.
+func RegisterPattern(name string, factory PatternFactory) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	if _, ok := patterns[name]; ok {
+		panic(fmt.Sprintf("pattern %q registered twice", name))
+	}
+	patterns[name] = factory
+}
+
+// NewPattern builds the registered pattern name with params.
+func NewPattern(name string, params PatternParams) (Pattern, error) {
+	patternsMu.RLock()
+	factory, ok := patterns[name]
+	patternsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown pattern %q", name)
+	}
+	p, err := factory(params)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// PatternNames lists the registered patterns in alphabetical order.
+func PatternNames() []string {
+	patternsMu.RLock()
+	defer patternsMu.RUnlock()
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultPatterns enables every built-in pattern with its default
+// parameters.
+func DefaultPatterns() []PatternConfig {
+	names := []string{
+		PatternStraight, PatternCurve, PatternBounce, PatternBackAndForth,
+		PatternJagged, PatternEase, PatternZigZag, PatternSpiral, PatternRandom,
+		PatternSmoothStep, PatternWave, PatternShortPause, PatternLongPause,
+	}
+	configs := make([]PatternConfig, 0, len(names))
+	for _, name := range names {
+		configs = append(configs, PatternConfig{Name: name})
+	}
+	return configs
+}
+
+// namedPattern is a configured pattern ready to be played.
+type namedPattern struct {
+	name string
+	Pattern
+}
+
+// buildPatterns builds the configured patterns, all built-ins when none are
+// configured.
+func buildPatterns(configs []PatternConfig) ([]namedPattern, error) {
+	if len(configs) == 0 {
+		configs = DefaultPatterns()
+	}
+	built := make([]namedPattern, 0, len(configs))
+	for _, pc := range configs {
+		p, err := NewPattern(pc.Name, pc.Params)
+		if err != nil {
+			return nil, err
+		}
+		built = append(built, namedPattern{name: pc.Name, Pattern: p})
+	}
+	return built, nil
+}
+
+// builtin registers a pattern whose parameters all have defaults. Unknown
+// parameters are rejected so typos in the config file do not go unnoticed.
+func builtin(name string, defaults PatternParams, build func(p PatternParams) Pattern) {
+	RegisterPattern(name, func(params PatternParams) (Pattern, error) {
+		p := PatternParams{}
+		for k, v := range defaults {
+			p[k] = v
+		}
+		for k, v := range params {
+			if _, ok := defaults[k]; !ok {
+				return nil, fmt.Errorf("unknown parameter %q", k)
+			}
+			p[k] = v
+		}
+		return build(p), nil
+	})
+}
+
+// pause holds for a random time between min and max.
+type pause struct {
+	min, max time.Duration
+}
+
+func (p pause) Point(t, dx, dy float64) (float64, float64) {
+	return 0, 0
+}
+
+func (p pause) Hold() time.Duration {
+	return p.min + time.Duration(rand.Int63n(int64(p.max-p.min)+1))
+}
+
+func newPause(p PatternParams) Pattern {
+	lo := time.Duration(p["min"] * float64(time.Second))
+	hi := time.Duration(p["max"] * float64(time.Second))
+	return pause{min: max(0, lo), max: max(0, lo, hi)}
+}
+
+// straight moves in a line, as chasing turrets always do.
+var straight = PatternFunc(func(t, dx, dy float64) (float64, float64) {
+	return dx * t, dy * t
+})
+
+func init() {
+	builtin(PatternStraight, nil, func(PatternParams) Pattern { return straight })
+	builtin(PatternCurve, PatternParams{"amplitude": 10}, func(p PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			return dx * t, dy*t + p["amplitude"]*math.Sin(t*math.Pi)
+		})
+	})
+	builtin(PatternBounce, PatternParams{"amplitude": 0.1, "frequency": 3}, func(p PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			b := t + p["amplitude"]*math.Sin(p["frequency"]*math.Pi*t)
+			return dx * b, dy * b
+		})
+	})
+	builtin(PatternBackAndForth, nil, func(PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			b := t * 2
+			if t >= 0.5 {
+				b = 1 - ((t - 0.5) * 2)
+			}
+			return dx * b, dy * b
+		})
+	})
+	builtin(PatternJagged, PatternParams{"amplitude": 2}, func(p PatternParams) Pattern {
+		n := max(0, int(p["amplitude"]))
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			jag := float64(rand.Intn(2*n+1) - n)
+			return dx*t + jag, dy*t - jag
+		})
+	})
+	builtin(PatternEase, nil, func(PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			e := -0.5 * (math.Cos(math.Pi*t) - 1)
+			return dx * e, dy * e
+		})
+	})
+	builtin(PatternZigZag, PatternParams{"amplitude": 5, "frequency": 10}, func(p PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			return dx * t, dy*t + p["amplitude"]*math.Sin(p["frequency"]*t*math.Pi)
+		})
+	})
+	builtin(PatternSpiral, PatternParams{"radius": 10, "turns": 2}, func(p PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			r := p["radius"] * (1 - t)
+			a := 2 * math.Pi * p["turns"] * t
+			return dx*t + r*math.Cos(a), dy*t + r*math.Sin(a)
+		})
+	})
+	builtin(PatternRandom, PatternParams{"amplitude": 3}, func(p PatternParams) Pattern {
+		n := max(0, int(p["amplitude"]))
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			return dx*t + float64(rand.Intn(2*n+1)-n), dy*t + float64(rand.Intn(2*n+1)-n)
+		})
+	})
+	builtin(PatternSmoothStep, nil, func(PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			s := t * t * (3 - 2*t)
+			return dx * s, dy * s
+		})
+	})
+	builtin(PatternWave, PatternParams{"amplitude": 8, "frequency": 4}, func(p PatternParams) Pattern {
+		return PatternFunc(func(t, dx, dy float64) (float64, float64) {
+			return dx * t, dy*t + p["amplitude"]*math.Sin(p["frequency"]*t*math.Pi)
+		})
+	})
+	builtin(PatternShortPause, PatternParams{"min": 1, "max": 5}, newPause)
+	builtin(PatternLongPause, PatternParams{"min": 5, "max": 34}, newPause)
+}
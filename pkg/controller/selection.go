@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"slices"
+)
+
+// PatternSelection weighs the configured patterns for each play state.
+type PatternSelection struct {
+	// Weights maps a play state to pattern weights. Patterns missing from
+	// a state's table weigh 1, and a weight of 0 never picks the pattern.
+	Weights map[State]map[string]float64 `json:"weights"`
+	// MaxRepeats is how many times in a row a turret may play the same
+	// pattern, 0 for no limit.
+	MaxRepeats int `json:"maxRepeats"`
+}
+
+// DefaultPatternSelection keeps slow play calm and fast play erratic.
+func DefaultPatternSelection() PatternSelection {
+	return PatternSelection{
+		Weights: map[State]map[string]float64{
+			Slow: {
+				PatternEase: 4, PatternSmoothStep: 4, PatternShortPause: 3, PatternLongPause: 2,
+				PatternJagged: 0.25, PatternZigZag: 0.25, PatternRandom: 0.25, PatternBounce: 0.5,
+			},
+			Fast: {
+				PatternJagged: 4, PatternZigZag: 4, PatternBounce: 4,
+				PatternEase: 0.5, PatternSmoothStep: 0.5, PatternShortPause: 0.5, PatternLongPause: 0,
+			},
+		},
+		MaxRepeats: 2,
+	}
+}
+
+func (s PatternSelection) Validate() error {
+	var errs []error
+	if s.MaxRepeats < 0 {
+		errs = append(errs, fmt.Errorf("maxRepeats must not be negative, got %d", s.MaxRepeats))
+	}
+	registered := PatternNames()
+	for state, weights := range s.Weights {
+		if state < Slow || state > Fast {
+			errs = append(errs, fmt.Errorf("weights: %d is not a play state", state))
+		}
+		for name, w := range weights {
+			if !slices.Contains(registered, name) {
+				errs = append(errs, fmt.Errorf("weights[%d]: unknown pattern %q", state, name))
+			}
+			if w < 0 {
+				errs = append(errs, fmt.Errorf("weights[%d]: %s must not be negative, got %g", state, name, w))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s PatternSelection) weight(state State, name string) float64 {
+	if w, ok := s.Weights[state][name]; ok {
+		return w
+	}
+	return 1
+}
+
+// nextPattern picks one of the configured patterns by the weights of the
+// turret's state, skipping the last one once it has repeated MaxRepeats
+// times. If that was the only pattern with a weight it plays again anyway;
+// patterns are only picked evenly if every weight is 0.
+func (t *Turret) nextPattern() namedPattern {
+	t.c.selectionMu.Lock()
+	sel := t.c.Configuration.PatternSelection
+	t.c.selectionMu.Unlock()
+
+	weigh := func(limitRepeats bool) ([]float64, float64) {
+		weights := make([]float64, len(t.c.patterns))
+		total := 0.0
+		for i, p := range t.c.patterns {
+			w := sel.weight(t.State, p.name)
+			if limitRepeats && sel.MaxRepeats > 0 && t.repeats >= sel.MaxRepeats && p.name == t.lastPattern {
+				w = 0
+			}
+			weights[i] = w
+			total += w
+		}
+		return weights, total
+	}
+	weights, total := weigh(true)
+	if total == 0 {
+		weights, total = weigh(false)
+	}
+	picked := t.c.patterns[rand.Intn(len(t.c.patterns))]
+	if total > 0 {
+		r := rand.Float64() * total
+		for i, w := range weights {
+			if r < w {
+				picked = t.c.patterns[i]
+				break
+			}
+			r -= w
+		}
+	}
+	if picked.name == t.lastPattern {
+		t.repeats++
+	} else {
+		t.lastPattern = picked.name
+		t.repeats = 1
+	}
+	return picked
+}
+
+// patternsResponse lists the playable patterns with their weights.
+type patternsResponse struct {
+	Patterns  []string         `json:"patterns"`
+	Selection PatternSelection `json:"selection"`
+}
+
+// handlePatterns returns the pattern weights, or replaces them on POST.
+func (c *Controller) handlePatterns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		resp := patternsResponse{}
+		for _, p := range c.patterns {
+			if !slices.Contains(resp.Patterns, p.name) {
+				resp.Patterns = append(resp.Patterns, p.name)
+			}
+		}
+		c.selectionMu.Lock()
+		resp.Selection = c.Configuration.PatternSelection
+		c.selectionMu.Unlock()
+		json.NewEncoder(w).Encode(resp)
+	case http.MethodPost:
+		var sel PatternSelection
+		if err := json.NewDecoder(r.Body).Decode(&sel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := sel.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.selectionMu.Lock()
+		c.Configuration.PatternSelection = sel
+		c.selectionMu.Unlock()
+		c.saveConfig()
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
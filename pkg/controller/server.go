@@ -5,10 +5,11 @@ import (
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
+	"sync"
 	"time"
+
+	"github.com/Seann-Moser/lazer/pkg/io"
 )
 
 //go:embed index.html
@@ -33,6 +34,7 @@ type GeneralSchedule struct {
 	OnDuration time.Duration `json:"onDuration,omitempty"`
 	StartTime  string        `json:"startTime,omitempty"` // hour,minute of the day
 	State      State         `json:"state,omitempty"`
+	Brightness float64       `json:"brightness,omitempty"` // laser percent while this entry is active, 0 for the state's default
 }
 
 func (c *Controller) StartServer(ctx context.Context) {
@@ -43,6 +45,15 @@ func (c *Controller) StartServer(ctx context.Context) {
 	http.HandleFunc("/", serveFrontend)
 	http.HandleFunc("/api/get", c.handleGetSettings)
 	http.HandleFunc("/api/save", c.handleSaveSettings)
+	http.HandleFunc("/api/status", c.handleStatus)
+	http.HandleFunc("/api/turrets", c.handleTurrets)
+	http.HandleFunc("/api/turrets/state", c.handleTurretState)
+	http.HandleFunc("/api/patterns", c.handlePatterns)
+	http.HandleFunc("/api/floor", c.handleFloor)
+	http.HandleFunc("/api/zones", c.handleZones)
+	http.HandleFunc("/api/playarea", c.handlePlayArea)
+	c.buttonLog.watch(ctx, "left", c.LeftButton)
+	c.buttonLog.watch(ctx, "right", c.RightButton)
 
 	fmt.Println("Server running on http://0.0.0.0:8080")
 
@@ -64,14 +75,175 @@ func (c *Controller) handleSaveSettings(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	c.Configuration.Setting = newSetting
-	data, err := json.Marshal(c.Configuration)
-	if err != nil {
-		log.Printf("failed marshalling config file")
+	c.saveConfig()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+const buttonLogSize = 20
+
+// buttonLog keeps the most recent events of each button for the status page.
+type buttonLog struct {
+	mu     sync.Mutex
+	events map[string][]io.ButtonEvent
+}
+
+func (l *buttonLog) watch(ctx context.Context, name string, b io.ButtonSource) {
+	if b == nil {
+		return
+	}
+	sub := b.Subscribe()
+	l.mu.Lock()
+	if l.events == nil {
+		l.events = map[string][]io.ButtonEvent{}
+	}
+	l.mu.Unlock()
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-sub.Events():
+				l.mu.Lock()
+				events := append(l.events[name], e)
+				if len(events) > buttonLogSize {
+					events = events[len(events)-buttonLogSize:]
+				}
+				l.events[name] = events
+				l.mu.Unlock()
+			}
+		}
+	}()
+}
+
+type ButtonStatus struct {
+	Dropped uint64           `json:"dropped"`
+	Recent  []io.ButtonEvent `json:"recent"`
+}
+
+type TurretStatus struct {
+	Name          string       `json:"name"`
+	State         State        `json:"state"`
+	Follow        string       `json:"follow,omitempty"`
+	X             float64      `json:"x"`
+	Y             float64      `json:"y"`
+	Brightness    float64      `json:"brightness"`
+	WatchdogTrips int          `json:"watchdogTrips"`
+	LastTrip      string       `json:"lastTrip,omitempty"`
+	StepLatency   LatencyStats `json:"stepLatency"`
+}
+
+type Status struct {
+	State   State                   `json:"state"`
+	Buttons map[string]ButtonStatus `json:"buttons"`
+	Turrets []TurretStatus          `json:"turrets"`
+	// Faults lists the hardware subsystems that are unavailable.
+	Faults map[string]string `json:"faults,omitempty"`
+	// FaultStats counts hardware failures and recoveries since startup.
+	FaultStats map[string]io.FaultStats `json:"faultStats,omitempty"`
+	Motion     MotionStatus             `json:"motion"`
+}
+
+func (t *Turret) status() TurretStatus {
+	s := TurretStatus{
+		Name:  t.Name,
+		State: t.State,
+	}
+	if t.leader != nil {
+		s.Follow = t.leader.Name
+	}
+	if t.c.Servos != nil {
+		s.X, s.Y = t.Position()
 	}
-	err = os.WriteFile(configFile, data, 0777)
-	if err != nil {
-		log.Printf("failed saving config file")
+	if t.Laser != nil {
+		s.Brightness = t.Laser.Brightness()
 	}
+	s.WatchdogTrips, s.LastTrip = t.watchdog.Trips()
+	s.StepLatency = t.latency.Stats()
+	return s
+}
 
+func (c *Controller) turretStatus() []TurretStatus {
+	statuses := make([]TurretStatus, 0, len(c.Turrets))
+	for _, t := range c.Turrets {
+		statuses = append(statuses, t.status())
+	}
+	return statuses
+}
+
+// faults merges the failures recorded at startup with the ones the servo
+// driver currently reports.
+func (c *Controller) faults() map[string]string {
+	faults := map[string]string{}
+	for name, err := range c.setupFaults {
+		faults[name] = err.Error()
+	}
+	if r, ok := c.Servos.(io.FaultReporter); ok {
+		for name, err := range r.Faults() {
+			faults[name] = err.Error()
+		}
+	}
+	return faults
+}
+
+func (c *Controller) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := Status{
+		State:   c.State,
+		Buttons: map[string]ButtonStatus{},
+		Turrets: c.turretStatus(),
+		Faults:  c.faults(),
+		Motion:  c.motionLog.Status(),
+	}
+	if fc, ok := c.Servos.(io.FaultCounter); ok {
+		status.FaultStats = fc.FaultStats()
+	}
+	for name, b := range map[string]io.ButtonSource{"left": c.LeftButton, "right": c.RightButton} {
+		if b == nil {
+			continue
+		}
+		c.buttonLog.mu.Lock()
+		status.Buttons[name] = ButtonStatus{
+			Dropped: b.Dropped(),
+			Recent:  append([]io.ButtonEvent(nil), c.buttonLog.events[name]...),
+		}
+		c.buttonLog.mu.Unlock()
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func (c *Controller) handleTurrets(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(c.turretStatus())
+}
+
+// handleTurretState sets the play state of a single turret, e.g.
+// {"name":"hall","state":"Fast"}.
+func (c *Controller) handleTurretState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Name  string `json:"name"`
+		State State  `json:"state"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	t := c.Turret(req.Name)
+	if t == nil {
+		http.Error(w, "unknown turret: "+req.Name, http.StatusNotFound)
+		return
+	}
+	if req.State == Configuring {
+		http.Error(w, "turrets are configured together from the buttons", http.StatusBadRequest)
+		return
+	}
+	c.active = time.Now()
+	t.setState(r.Context(), req.State)
+	if req.State == Off {
+		t.park()
+	}
 	w.WriteHeader(http.StatusOK)
 }
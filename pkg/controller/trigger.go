@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Seann-Moser/lazer/pkg/io"
+)
+
+// MotionTrigger starts play when the motion sensor sees the cat.
+type MotionTrigger struct {
+	Enabled bool  `json:"enabled"`
+	State   State `json:"state"` // play state a triggered session starts in
+	// Armed are the windows in which motion may start a session. Motion is
+	// ignored outside them; no windows means always armed.
+	Armed []ArmedWindow `json:"armed"`
+	// Cooldown after a triggered session starts before motion may start
+	// another one.
+	Cooldown time.Duration `json:"cooldown"`
+	DailyCap int           `json:"dailyCap"` // triggered sessions per day, 0 for no cap
+}
+
+// ArmedWindow is a daily time window, like a schedule entry.
+type ArmedWindow struct {
+	StartTime  string        `json:"startTime"` // hour:minute of the day
+	OnDuration time.Duration `json:"onDuration"`
+}
+
+func DefaultMotionTrigger() MotionTrigger {
+	return MotionTrigger{
+		State:    Slow,
+		Cooldown: 30 * time.Minute,
+		DailyCap: 5,
+	}
+}
+
+func (m MotionTrigger) Validate() error {
+	if m.State <= Configuring {
+		return fmt.Errorf("motion trigger state must be a play state")
+	}
+	if m.Cooldown < 0 || m.DailyCap < 0 {
+		return fmt.Errorf("motion trigger cooldown and daily cap must not be negative")
+	}
+	for _, w := range m.Armed {
+		if _, err := time.Parse("15:04", w.StartTime); err != nil {
+			return fmt.Errorf("motion trigger armed window: %w", err)
+		}
+	}
+	return nil
+}
+
+// armed reports whether now falls in one of the armed windows.
+func (m MotionTrigger) armed(now time.Time) bool {
+	if len(m.Armed) == 0 {
+		return true
+	}
+	for _, w := range m.Armed {
+		if isNowInSchedule(now, GeneralSchedule{StartTime: w.StartTime, OnDuration: w.OnDuration}) {
+			return true
+		}
+	}
+	return false
+}
+
+// MotionStatus reports what the motion trigger has been doing.
+type MotionStatus struct {
+	SessionsToday int       `json:"sessionsToday"`
+	LastTrigger   time.Time `json:"lastTrigger,omitempty"`
+	LastIgnored   string    `json:"lastIgnored,omitempty"` // why the latest motion did not start play
+}
+
+// motionLog tracks triggered sessions for the cooldown and daily cap.
+type motionLog struct {
+	mu     sync.Mutex
+	day    string
+	status MotionStatus
+}
+
+// allow decides whether motion at now may start a session and records it
+// if so. It returns why not otherwise.
+func (l *motionLog) allow(m MotionTrigger, now time.Time) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if day := now.Format(time.DateOnly); day != l.day {
+		l.day = day
+		l.status.SessionsToday = 0
+	}
+	reason := ""
+	switch {
+	case !m.armed(now):
+		reason = "not armed"
+	case !l.status.LastTrigger.IsZero() && now.Sub(l.status.LastTrigger) < m.Cooldown:
+		reason = "cooling down"
+	case m.DailyCap > 0 && l.status.SessionsToday >= m.DailyCap:
+		reason = "daily cap reached"
+	}
+	l.status.LastIgnored = reason
+	if reason == "" {
+		l.status.LastTrigger = now
+		l.status.SessionsToday++
+	}
+	return reason
+}
+
+func (l *motionLog) Status() MotionStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.status
+}
+
+// handleMotion starts a session when the sensor sees movement while
+// nothing is playing.
+func (c *Controller) handleMotion(ctx context.Context, b io.ButtonEvent) {
+	trigger := c.Configuration.MotionTrigger
+	if b.Gesture != io.Press || !trigger.Enabled || c.configuring || c.playing() {
+		return
+	}
+	if reason := c.motionLog.allow(trigger, time.Now()); reason != "" {
+		log.Printf("motion ignored: %s", reason)
+		return
+	}
+	log.Printf("motion detected, starting %s", trigger.State)
+	c.brightnessOverride = 0
+	c.active = time.Now()
+	c.ChangeState(ctx, trigger.State)
+}
@@ -0,0 +1,436 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Seann-Moser/lazer/pkg/io"
+)
+
+const (
+	MainTurret = "main"
+
+	ModeIndependent = "independent"
+	ModeChase       = "chase"
+)
+
+// Limits is the rectangle of servo angles a turret plays in.
+type Limits struct {
+	MinXAngle float64
+	MinYAngle float64
+	MaxXAngle float64
+	MaxYAngle float64
+}
+
+// TurretConfig describes an additional pan/tilt head. The main turret is
+// described by Hardware and the top-level limits.
+type TurretConfig struct {
+	Name        string      `json:"name"`
+	PanChannel  int         `json:"panChannel"`
+	TiltChannel int         `json:"tiltChannel"`
+	Laser       LaserWiring `json:"laser"`
+	Limits
+	Mode   string           `json:"mode"`   // "independent" or "chase"
+	Follow string           `json:"follow"` // turret chased in chase mode, main by default
+	Floor  FloorCalibration `json:"floor"`
+	// PlayArea replaces the rectangle of the limits for picking targets.
+	PlayArea []Polygon `json:"playArea"`
+}
+
+// Turret is one pan/tilt head with its own laser and play loop.
+type Turret struct {
+	Name        string
+	c           *Controller
+	motorX      int
+	motorY      int
+	Laser       io.Laser
+	laserEffect *io.EffectLaser
+	limits      *Limits
+	leader      *Turret // set in chase mode
+	State       State
+	speed       float64
+	watchdog    watchdog
+	latency     stepLatency
+	lastPattern string
+	repeats     int        // times lastPattern was played in a row
+	playArea    *[]Polygon // guarded by c.areaMu
+	floorConfig *FloorCalibration
+	floor       *floorMap     // nil until the floor is calibrated
+	calibration *floorSession // guarded by c.areaMu
+	// laserMu guards laserOn and blanked, which the handlers aiming the
+	// dot share with play.
+	laserMu sync.Mutex
+	laserOn bool // what play asked for
+	blanked bool // off while crossing a keep-out zone
+	stalled bool // the last move failed, so the laser stays off
+}
+
+func newTurret(c *Controller, name string, motorX, motorY int, laser io.Laser, limits *Limits, area *[]Polygon, floor *FloorCalibration) *Turret {
+	t := &Turret{
+		Name:        name,
+		c:           c,
+		motorX:      motorX,
+		motorY:      motorY,
+		Laser:       laser,
+		limits:      limits,
+		playArea:    area,
+		floorConfig: floor,
+	}
+	if len(floor.Points) > 0 {
+		m, err := fitFloor(*floor)
+		if err != nil {
+			log.Printf("%s: ignoring floor calibration: %s", name, err)
+		}
+		t.floor = m
+	}
+	if t.Laser != nil && c.Configuration.Laser.Effect.Type != "" {
+		if err := c.Configuration.Laser.Effect.Validate(); err != nil {
+			log.Printf("ignoring laser effect: %s", err)
+		} else {
+			t.laserEffect = io.NewEffectLaser(t.Laser, c.Configuration.Laser.Effect)
+			t.Laser = t.laserEffect
+		}
+	}
+	return t
+}
+
+// run drives the turret until ctx is done.
+func (t *Turret) run(ctx context.Context) {
+	if t.laserEffect != nil {
+		go t.laserEffect.Run(ctx)
+	}
+	go t.runWatchdog(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			t.play(ctx)
+		}
+	}
+}
+
+// setState switches the turret to a play state, or parks it for Off.
+func (t *Turret) setState(ctx context.Context, state State) {
+	previous := t.State
+	switch state {
+	case Off:
+		t.fadeLaser(ctx, false)
+	case Slow:
+		t.speed = 0.25
+	case Medium:
+		t.speed = 0.5
+	case Fast:
+		t.speed = 1
+	}
+	t.State = state
+	if state > Configuring && previous <= Configuring {
+		t.fadeLaser(ctx, true)
+	}
+}
+
+// park centers the turret's servos.
+func (t *Turret) park() {
+	if t.c.Servos == nil {
+		return
+	}
+	_, _ = t.c.Servos.SetXY(t.motorX, t.motorY, 90, 90)
+}
+
+// Position returns the estimated real angles of the turret.
+func (t *Turret) Position() (float64, float64) {
+	x, _ := t.c.Servos.Position(t.motorX)
+	y, _ := t.c.Servos.Position(t.motorY)
+	return x, y
+}
+
+// lit reports whether the turret's laser is shining, for a chasing turret
+// to mirror. It covers pulsing, blanking and Off alike.
+func (t *Turret) lit() bool {
+	if t.Laser == nil {
+		return t.State > Configuring
+	}
+	return t.Laser.Brightness() > 0
+}
+
+// chaseTarget is where a chasing turret heads next: the leader's current
+// position, kept inside this turret's limits. Turrets are assumed to be
+// mounted together so their angles are comparable.
+func (t *Turret) chaseTarget() (float64, float64) {
+	x, y := t.leader.Position()
+	x = math.Max(t.limits.MinXAngle, math.Min(t.limits.MaxXAngle, x))
+	y = math.Max(t.limits.MinYAngle, math.Min(t.limits.MaxYAngle, y))
+	return math.Max(0, math.Min(180, x)), math.Max(0, math.Min(180, y))
+}
+
+// play runs one iteration of the motion loop. A panic turns the laser off
+// instead of leaving it parked on one spot.
+func (t *Turret) play(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s motion loop panicked: %v", t.Name, r)
+			t.setLaser(false)
+		}
+	}()
+	if t.holding() {
+		// The dot stays as it is to aim with, but never lights while Off.
+		if t.State <= Configuring {
+			t.setLaser(false)
+		}
+		t.pause(ctx, 100*time.Millisecond)
+		return
+	}
+	if t.State <= Configuring {
+		t.setLaser(false)
+		x, y := t.c.Servos.GetXY(t.motorX, t.motorY)
+		t.watchdog.feed(x, y)
+		time.Sleep(1 * time.Second)
+		return
+	}
+	if t.leader != nil {
+		t.setLaser(!t.stalled && t.leader.lit())
+		x, y := t.chaseTarget()
+		if cx, cy := t.c.Servos.GetXY(t.motorX, t.motorY); math.Abs(cx-x) < 0.1 && math.Abs(cy-y) < 0.1 {
+			t.pause(ctx, 100*time.Millisecond)
+			return
+		}
+		if err := t.moved(t.moveTo(ctx, x, y, straight)); err != nil {
+			log.Printf("%s failed to chase %s: %s", t.Name, t.leader.Name, err)
+			t.pause(ctx, time.Second)
+		}
+		return
+	}
+	t.setLaser(!t.stalled && rand.Float64() <= t.c.pulsePercent)
+	x, y := t.getRandomXY()
+	pattern := t.nextPattern()
+	fmt.Printf("%s x: %.1f y:%.1f Pattern:%s\n", t.Name, x, y, pattern.name)
+	err := t.moved(t.moveTo(ctx, x, y, pattern))
+	if err != nil {
+		log.Printf("%s failed to  move to point: %s", t.Name, err)
+		t.pause(ctx, time.Second)
+	}
+}
+
+// moved records the outcome of a move and returns its error. While moves
+// fail, for example with the PCA9685 missing, the laser is kept off
+// instead of shining on one spot until the watchdog notices.
+func (t *Turret) moved(err error) error {
+	t.stalled = err != nil && !errors.Is(err, context.Canceled)
+	if t.stalled {
+		t.setLaser(false)
+	}
+	return err
+}
+
+// pause holds the current position for d, feeding the watchdog meanwhile.
+// While the laser is on the position is commanded again every second, so
+// idle detaching does not let the servos go limp under a lit dot.
+func (t *Turret) pause(ctx context.Context, d time.Duration) {
+	deadline := time.Now().Add(d)
+	for time.Now().Before(deadline) {
+		x, y := t.c.Servos.GetXY(t.motorX, t.motorY)
+		t.watchdog.feed(x, y)
+		if t.laserRequested() && !t.stalled {
+			_, _ = t.c.Servos.SetXY(t.motorX, t.motorY, x, y)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(min(time.Second, time.Until(deadline))):
+		}
+	}
+}
+
+// getRandomXY picks a target outside every keep-out zone. If the zones
+// leave hardly any room it stays where it is.
+func (t *Turret) getRandomXY() (float64, float64) {
+	zones := t.keepOut()
+	for range 100 {
+		x, y := t.sampleXY()
+		if !blocked(zones, x, y, x, y) {
+			return x, y
+		}
+	}
+	return t.c.Servos.GetXY(t.motorX, t.motorY)
+}
+
+func (t *Turret) sampleXY() (float64, float64) {
+	if x, y, ok := t.randomAreaXY(); ok {
+		return x, y
+	}
+	if x, y, ok := t.randomFloorXY(); ok {
+		return x, y
+	}
+	rand.Seed(time.Now().UnixNano())
+	// Generate random X within configured range
+	x := t.limits.MinXAngle + rand.Float64()*(t.limits.MaxXAngle-t.limits.MinXAngle)
+	y := t.limits.MinYAngle + rand.Float64()*(t.limits.MaxYAngle-t.limits.MinYAngle)
+	// Clamp to 0–180 just in case
+	if x < 0 {
+		x = 0
+	} else if x > 180 {
+		x = 180
+	}
+
+	if y < 0 {
+		y = 0
+	} else if y > 180 {
+		y = 180
+	}
+
+	return x, y
+}
+
+func (t *Turret) moveTo(ctx context.Context, x, y float64, pattern Pattern) error {
+	if p, ok := pattern.(Pause); ok {
+		t.pause(ctx, p.Hold())
+		return ctx.Err()
+	}
+	currentX, currentY := t.c.Servos.GetXY(t.motorX, t.motorY)
+
+	if streamer, ok := t.c.Servos.(io.PointStreamer); ok {
+		return t.stream(ctx, streamer, t.route(pattern, currentX, currentY, x, y, math.Max(0.05, 0.5*t.speed)), currentX, currentY)
+	}
+
+	// Define step size (degrees per step), then calculate steps. Slow moves
+	// take finer steps so the dot glides instead of hopping.
+	r := t.route(pattern, currentX, currentY, x, y, math.Max(0.25, 2*t.speed))
+	zones := t.keepOut()
+	px, py := currentX, currentY
+
+	for i := 1; i <= r.steps; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			xi, yi := r.at(float64(i) / float64(r.steps))
+			// Blank before entering a keep-out zone, and light up again
+			// only once the step out of it is done.
+			inZone := blocked(zones, px, py, xi, yi)
+			if inZone {
+				t.blank(true)
+			}
+			px, py = xi, yi
+
+			start := time.Now()
+			waitDelay, err := t.c.Servos.SetXY(t.motorX, t.motorY, xi, yi)
+			if err != nil {
+				return err
+			}
+			written := time.Since(start)
+			t.latency.record(written)
+			t.watchdog.feed(xi, yi)
+
+			// Speed control
+			speed := t.speed * 100
+			if speed < 1 {
+				speed = 1
+			}
+			if speed > 100 {
+				speed = 100
+			}
+			speedMultiplier := 100.0 / float64(speed)
+			delay := time.Duration(float64(waitDelay) * speedMultiplier)
+			if r.stepTime > 0 {
+				// On the floor the step length sets the pace, as long as
+				// the servos keep up.
+				delay = max(r.stepTime, waitDelay)
+			}
+			// The time spent writing already counts towards the step.
+			time.Sleep(delay - written)
+			if !inZone {
+				t.blank(false)
+			}
+		}
+	}
+
+	return nil
+}
+
+// stream hands the whole path to a driver that plays it at its own rate,
+// such as galvo mirrors. Every point is one update, so the spacing of the
+// points sets the speed.
+func (t *Turret) stream(ctx context.Context, s io.PointStreamer, r route, x0, y0 float64) error {
+	zones := t.keepOut()
+	// Split the path where it enters or leaves a keep-out zone, so the
+	// laser can be blanked for the parts inside.
+	var chunks [][]io.Point
+	var inside []bool
+	px, py := x0, y0
+	for i := 1; i <= r.steps; i++ {
+		x, y := r.at(float64(i) / float64(r.steps))
+		in := blocked(zones, px, py, x, y)
+		if len(chunks) == 0 || inside[len(inside)-1] != in {
+			chunks = append(chunks, nil)
+			inside = append(inside, in)
+		}
+		chunks[len(chunks)-1] = append(chunks[len(chunks)-1], io.Point{X: x, Y: y})
+		px, py = x, y
+	}
+	for i, points := range chunks {
+		t.blank(inside[i])
+		start := time.Now()
+		d, err := s.Stream(points)
+		if err != nil {
+			return err
+		}
+		t.latency.record(time.Since(start))
+		last := points[len(points)-1]
+		t.watchdog.feed(last.X, last.Y)
+		t.pause(ctx, d)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// route is a planned move: the angles at progress p, split into steps.
+type route struct {
+	steps    int
+	at       func(p float64) (float64, float64)
+	stepTime time.Duration // set when the pace comes from the floor speed
+}
+
+// route plans a move following pattern from (x0, y0) to (x, y) in steps of
+// about stepSize degrees. With a floor calibration the pattern is drawn on
+// the floor, scaled so it keeps its size around the middle of the room, and
+// the steps are equally long there.
+func (t *Turret) route(pattern Pattern, x0, y0, x, y, stepSize float64) route {
+	if f := t.floor; f != nil {
+		fx0, fy0 := f.toFloor.apply(x0, y0)
+		fx, fy := f.toFloor.apply(x, y)
+		dx, dy := (fx-fx0)/f.scale, (fy-fy0)/f.scale
+		steps := max(1, int(math.Ceil(math.Hypot(dx, dy)/stepSize)))
+		length := math.Hypot(fx-fx0, fy-fy0) / float64(steps)
+		return route{
+			steps: steps,
+			at: func(p float64) (float64, float64) {
+				ox, oy := pattern.Point(p, dx, dy)
+				ax, ay := f.toAngles.apply(fx0+ox*f.scale, fy0+oy*f.scale)
+				return math.Max(0, math.Min(180, ax)), math.Max(0, math.Min(180, ay))
+			},
+			stepTime: time.Duration(length / (f.speed * t.speed) * float64(time.Second)),
+		}
+	}
+	dx, dy := x-x0, y-y0
+	return route{
+		steps: max(1, int(math.Ceil(math.Hypot(dx, dy)/stepSize))),
+		at: func(p float64) (float64, float64) {
+			return pathPoint(pattern, p, x0, y0, dx, dy)
+		},
+	}
+}
+
+// pathPoint is where a move from (x0, y0) by (dx, dy) following pattern
+// is at progress p, clamped to 0–180.
+func pathPoint(pattern Pattern, p, x0, y0, dx, dy float64) (float64, float64) {
+	ox, oy := pattern.Point(p, dx, dy)
+	return math.Max(0, math.Min(180, x0+ox)), math.Max(0, math.Min(180, y0+oy))
+}
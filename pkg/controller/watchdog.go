@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// WatchdogConfig bounds how long the laser may stay on without the motion
+// loop making progress.
+type WatchdogConfig struct {
+	Timeout     time.Duration `json:"timeout"`     // max time between feeds while the laser is on
+	StaleTarget time.Duration `json:"staleTarget"` // max time the laser may sit on one target
+}
+
+func DefaultWatchdogConfig() WatchdogConfig {
+	return WatchdogConfig{
+		Timeout:     5 * time.Second,
+		StaleTarget: time.Minute,
+	}
+}
+
+// watchdog is fed by the motion loop with every commanded position.
+type watchdog struct {
+	mu          sync.Mutex
+	lastFeed    time.Time
+	x, y        float64
+	targetSince time.Time
+	trips       int
+	lastTrip    string
+}
+
+func (w *watchdog) feed(x, y float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	w.lastFeed = now
+	if x != w.x || y != w.y || w.targetSince.IsZero() {
+		w.x, w.y = x, y
+		w.targetSince = now
+	}
+}
+
+// check returns why the watchdog should trip, or "" if it should not. A
+// turret holding still for calibration may sit on one target as long as it
+// takes to measure it.
+func (w *watchdog) check(config WatchdogConfig, now time.Time, holding bool) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if config.Timeout > 0 && now.Sub(w.lastFeed) > config.Timeout {
+		return "motion loop stalled"
+	}
+	if holding {
+		w.targetSince = now
+		return ""
+	}
+	if config.StaleTarget > 0 && now.Sub(w.targetSince) > config.StaleTarget {
+		return "target unchanged"
+	}
+	return ""
+}
+
+func (w *watchdog) trip(reason string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.trips++
+	w.lastTrip = reason
+	// Start the clocks over so one stall is counted once.
+	w.lastFeed = time.Now()
+	w.targetSince = w.lastFeed
+	return w.trips
+}
+
+// Trips returns how often the watchdog has fired and why it last did.
+func (w *watchdog) Trips() (int, string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.trips, w.lastTrip
+}
+
+// runWatchdog forces the laser off and parks the servos whenever the laser
+// is on and the motion loop stops feeding the watchdog. A turret held for
+// calibration or recording is not parked, so the spot being measured stays
+// where the user aimed it.
+func (t *Turret) runWatchdog(ctx context.Context) {
+	config := t.c.Configuration.Watchdog
+	interval := config.Timeout / 4
+	if interval <= 0 || interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if t.Laser == nil || t.Laser.Brightness() <= 0 {
+			continue
+		}
+		holding := t.holding()
+		reason := t.watchdog.check(config, time.Now(), holding)
+		if reason == "" {
+			continue
+		}
+		trips := t.watchdog.trip(reason)
+		log.Printf("%s watchdog tripped (%d): %s, forcing laser off", t.Name, trips, reason)
+		t.setLaser(false)
+		t.State = Off
+		if !holding {
+			t.park()
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// Vertex is a corner of a polygon in servo angles.
+type Vertex struct {
+	Pan  float64 `json:"pan"`
+	Tilt float64 `json:"tilt"`
+}
+
+// Polygon is a closed shape in servo angles; the last vertex connects back
+// to the first.
+type Polygon []Vertex
+
+func (p Polygon) Validate() error {
+	if len(p) < 3 {
+		return fmt.Errorf("need at least 3 vertices, got %d", len(p))
+	}
+	for i, v := range p {
+		if v.Pan < 0 || v.Pan > 180 || v.Tilt < 0 || v.Tilt > 180 {
+			return fmt.Errorf("vertex %d (%g, %g) outside 0-180", i, v.Pan, v.Tilt)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether (x, y) is inside the polygon, by counting the
+// edges a ray to the right of it crosses.
+func (p Polygon) Contains(x, y float64) bool {
+	inside := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+		if (a.Tilt > y) != (b.Tilt > y) && x < a.Pan+(y-a.Tilt)*(b.Pan-a.Pan)/(b.Tilt-a.Tilt) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Touches reports whether the segment from (x0, y0) to (x1, y1) enters the
+// polygon anywhere.
+func (p Polygon) Touches(x0, y0, x1, y1 float64) bool {
+	if p.Contains(x0, y0) || p.Contains(x1, y1) {
+		return true
+	}
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		if segmentsCross(x0, y0, x1, y1, p[j].Pan, p[j].Tilt, p[i].Pan, p[i].Tilt) {
+			return true
+		}
+	}
+	return false
+}
+
+func segmentsCross(ax, ay, bx, by, cx, cy, dx, dy float64) bool {
+	side := func(px, py, qx, qy, rx, ry float64) float64 {
+		return (qx-px)*(ry-py) - (qy-py)*(rx-px)
+	}
+	d1 := side(cx, cy, dx, dy, ax, ay)
+	d2 := side(cx, cy, dx, dy, bx, by)
+	d3 := side(ax, ay, bx, by, cx, cy)
+	d4 := side(ax, ay, bx, by, dx, dy)
+	return ((d1 > 0) != (d2 > 0)) && ((d3 > 0) != (d4 > 0))
+}
+
+// KeepOutZone is a region the laser never shines into, like the couch or a
+// window.
+type KeepOutZone struct {
+	Name   string  `json:"name"`
+	Turret string  `json:"turret,omitempty"` // the turret it applies to, every one when empty
+	Points Polygon `json:"points"`
+}
+
+func (z KeepOutZone) Validate() error {
+	if z.Name == "" {
+		return errors.New("zone name must be set")
+	}
+	if err := z.Points.Validate(); err != nil {
+		return fmt.Errorf("zone %s: %w", z.Name, err)
+	}
+	return nil
+}
+
+// keepOut returns the zones that apply to the turret.
+func (t *Turret) keepOut() []Polygon {
+	t.c.zoneMu.Lock()
+	defer t.c.zoneMu.Unlock()
+	var zones []Polygon
+	for _, z := range t.c.Configuration.KeepOut {
+		if z.Turret == "" || z.Turret == t.Name {
+			zones = append(zones, z.Points)
+		}
+	}
+	return zones
+}
+
+// blocked reports whether moving the dot from (x0, y0) to (x1, y1) crosses
+// any of zones.
+func blocked(zones []Polygon, x0, y0, x1, y1 float64) bool {
+	for _, z := range zones {
+		if z.Touches(x0, y0, x1, y1) {
+			return true
+		}
+	}
+	return false
+}
+
+// blank switches the laser off while the dot crosses a keep-out zone, and
+// back to what play asked for once it is out.
+func (t *Turret) blank(on bool) {
+	t.laserMu.Lock()
+	defer t.laserMu.Unlock()
+	if t.blanked == on {
+		return
+	}
+	t.blanked = on
+	t.writeLaser()
+}
+
+// aim moves the dot straight to (x, y) for the user to line it up. Like
+// moveTo it blanks the laser while the way there touches a keep-out zone,
+// and leaves it blanked if the dot comes to rest inside one.
+func (t *Turret) aim(x, y float64) error {
+	x = math.Max(0, math.Min(180, x))
+	y = math.Max(0, math.Min(180, y))
+	zones := t.keepOut()
+	x0, y0 := t.c.Servos.GetXY(t.motorX, t.motorY)
+	crossing := blocked(zones, x0, y0, x, y)
+	if crossing {
+		t.blank(true)
+	}
+	d, err := t.c.Servos.SetXY(t.motorX, t.motorY, x, y)
+	if err != nil {
+		return err
+	}
+	if crossing && !blocked(zones, x, y, x, y) {
+		// Light up again only once the dot is out.
+		time.Sleep(d)
+		t.blank(false)
+	}
+	return nil
+}
+
+// handleZones lists the keep-out zones on GET, adds or replaces one by name
+// on POST and removes the one named by ?name= on DELETE.
+func (c *Controller) handleZones(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		c.zoneMu.Lock()
+		zones := append([]KeepOutZone{}, c.Configuration.KeepOut...)
+		c.zoneMu.Unlock()
+		json.NewEncoder(w).Encode(zones)
+		return
+	case http.MethodPost:
+		var z KeepOutZone
+		if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := z.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if z.Turret != "" && c.Turret(z.Turret) == nil {
+			http.Error(w, "unknown turret: "+z.Turret, http.StatusBadRequest)
+			return
+		}
+		c.zoneMu.Lock()
+		replaced := false
+		for i := range c.Configuration.KeepOut {
+			if c.Configuration.KeepOut[i].Name == z.Name {
+				c.Configuration.KeepOut[i] = z
+				replaced = true
+			}
+		}
+		if !replaced {
+			c.Configuration.KeepOut = append(c.Configuration.KeepOut, z)
+		}
+		c.zoneMu.Unlock()
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		c.zoneMu.Lock()
+		zones := c.Configuration.KeepOut[:0]
+		for _, z := range c.Configuration.KeepOut {
+			if z.Name != name {
+				zones = append(zones, z)
+			}
+		}
+		found := len(zones) < len(c.Configuration.KeepOut)
+		c.Configuration.KeepOut = zones
+		c.zoneMu.Unlock()
+		if !found {
+			http.Error(w, "unknown zone: "+name, http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.saveConfig()
+	w.WriteHeader(http.StatusOK)
+}
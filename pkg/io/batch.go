@@ -0,0 +1,74 @@
+package io
+
+import (
+	"fmt"
+	"sort"
+)
+
+// led0OnL is the first of the four ON_L/ON_H/OFF_L/OFF_H registers of
+// channel 0. Each following channel sits 4 registers further.
+const led0OnL = 0x06
+
+// PWMWrite is the new on/off ticks of a single channel.
+type PWMWrite struct {
+	Channel int
+	On, Off uint16
+}
+
+// BatchPWMDriver is a PWMDriver that can update several channels in one
+// bus transaction, so they switch in the same PWM period.
+type BatchPWMDriver interface {
+	PWMDriver
+	SetPWMs(writes []PWMWrite) error
+}
+
+// setPWMs updates all writes, in one transaction when the driver supports it.
+func setPWMs(d PWMDriver, writes []PWMWrite) error {
+	if b, ok := d.(BatchPWMDriver); ok {
+		return b.SetPWMs(writes)
+	}
+	for _, w := range writes {
+		if err := d.SetPWM(w.Channel, w.On, w.Off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchRegisters encodes writes as a single auto-increment register write.
+// It reports false unless the channels are adjacent, since a gap would
+// overwrite the channels in between.
+func batchRegisters(writes []PWMWrite) ([]byte, bool) {
+	if len(writes) == 0 {
+		return nil, false
+	}
+	sorted := append([]PWMWrite(nil), writes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Channel < sorted[j].Channel })
+	for i, w := range sorted {
+		if w.Channel < 0 || w.Channel > 15 || (i > 0 && w.Channel != sorted[i-1].Channel+1) {
+			return nil, false
+		}
+	}
+	data := []byte{byte(led0OnL + 4*sorted[0].Channel)}
+	for _, w := range sorted {
+		data = append(data, byte(w.On), byte(w.On>>8), byte(w.Off), byte(w.Off>>8))
+	}
+	return data, true
+}
+
+// writeBatch sends writes with write when they form one register block and
+// falls back to one SetPWM per channel otherwise.
+func writeBatch(d PWMDriver, write func([]byte) error, writes []PWMWrite) error {
+	if data, ok := batchRegisters(writes); ok {
+		if err := write(data); err != nil {
+			return fmt.Errorf("batched write of %d channels: %w", len(writes), err)
+		}
+		return nil
+	}
+	for _, w := range writes {
+		if err := d.SetPWM(w.Channel, w.On, w.Off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
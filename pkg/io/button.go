@@ -2,75 +2,410 @@ package io
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/warthog618/go-gpiocdev"
 )
 
+// Gesture is the kind of interaction a ButtonEvent reports.
+type Gesture int
+
+const (
+	// Press fires as soon as the button goes down.
+	Press Gesture = iota
+	// Release fires whenever the button comes back up.
+	Release
+	// Click is a short press that was not followed by a second one within
+	// the double-click window.
+	Click
+	// DoubleClick is two short presses within the double-click window.
+	DoubleClick
+	// LongPress fires once while the button is still held.
+	LongPress
+	// HoldRepeat fires repeatedly after LongPress until the button is released.
+	HoldRepeat
+)
+
+func (g Gesture) String() string {
+	switch g {
+	case Press:
+		return "Press"
+	case Release:
+		return "Release"
+	case Click:
+		return "Click"
+	case DoubleClick:
+		return "DoubleClick"
+	case LongPress:
+		return "LongPress"
+	case HoldRepeat:
+		return "HoldRepeat"
+	}
+	return fmt.Sprintf("Gesture(%d)", int(g))
+}
+
+// GestureConfig holds the timing thresholds used to recognize gestures.
+type GestureConfig struct {
+	Debounce    time.Duration `json:"debounce"`    // edges closer than this are ignored
+	DoubleClick time.Duration `json:"doubleClick"` // max gap between two clicks, 0 disables double clicks
+	LongPress   time.Duration `json:"longPress"`   // hold time before LongPress
+	HoldRepeat  time.Duration `json:"holdRepeat"`  // interval between HoldRepeat events, 0 disables them
+}
+
+func DefaultGestureConfig() GestureConfig {
+	return GestureConfig{
+		Debounce:    10 * time.Millisecond,
+		DoubleClick: 300 * time.Millisecond,
+		LongPress:   2 * time.Second,
+		HoldRepeat:  500 * time.Millisecond,
+	}
+}
+
+// ButtonConfig configures gesture recognition and event delivery for a
+// button.
+type ButtonConfig struct {
+	GestureConfig
+	QueueSize int            `json:"queueSize"` // events buffered per subscriber
+	Overflow  OverflowPolicy `json:"overflow"`  // what to do when a subscriber falls behind
+}
+
+func DefaultButtonConfig() ButtonConfig {
+	return ButtonConfig{
+		GestureConfig: DefaultGestureConfig(),
+		QueueSize:     DefaultEventQueueSize,
+		Overflow:      DropOldest,
+	}
+}
+
 type Button struct {
-	offset        int
-	status        bool
-	start         time.Time
-	Event         chan ButtonEvent
-	hadFirstPress bool
+	mu       sync.Mutex
+	config   GestureConfig
+	status   bool // true while released
+	active   bool // line level while pressed
+	lastEdge time.Time
+	start    time.Time
+	held     bool // LongPress already fired for the current press
+	clicks   int
+	clickDur time.Duration // hold time of the pending click
+	longT    *time.Timer
+	repeatT  *time.Ticker
+	holdDone chan struct{} // closed when the current hold ends
+	clickT   *time.Timer
+	hub      *eventHub
 }
+
+// ButtonEvent is a recognized gesture. Status is true once the button has
+// been released and Duration how long the button has
+// been held.
 type ButtonEvent struct {
-	Status   bool
-	Duration time.Duration
+	Gesture  Gesture       `json:"gesture"`
+	Status   bool          `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Time     time.Time     `json:"time"`
+}
+
+func (g Gesture) MarshalText() ([]byte, error) {
+	return []byte(g.String()), nil
+}
+
+func newButton(config ButtonConfig) *Button {
+	return &Button{
+		config: config.GestureConfig,
+		status: true,
+		hub:    newEventHub(config.QueueSize, config.Overflow),
+	}
 }
 
 func (b *Button) eventHandler(evt gpiocdev.LineEvent) {
-	var diff time.Duration
+	rising := evt.Type != gpiocdev.LineEventFallingEdge
+	b.handleEdge(rising != b.active)
+}
+
+// handleEdge feeds a single press or release into the gesture recognizer.
+func (b *Button) handleEdge(released bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.status == released {
+		return
+	}
+	if !b.lastEdge.IsZero() && now.Sub(b.lastEdge) < b.config.Debounce {
+		return
+	}
+	b.lastEdge = now
+	b.status = released
+	if released {
+		b.released(now)
+	} else {
+		b.pressed(now)
+	}
+}
+
+func (b *Button) pressed(now time.Time) {
+	b.start = now
+	b.held = false
+	// A pending click is decided on this press's release.
+	b.stopClick()
+	b.emit(Press, 0)
+	if b.config.LongPress > 0 {
+		b.longT = time.AfterFunc(b.config.LongPress, b.longPress)
+	}
+}
 
-	rising := true
-	if evt.Type == gpiocdev.LineEventFallingEdge {
-		rising = false
+func (b *Button) released(now time.Time) {
+	held := now.Sub(b.start)
+	b.stopHold()
+	b.emit(Release, held)
+	if b.held {
+		b.clicks = 0
+		return
 	}
-	if b.status == rising {
-		if b.start.IsZero() {
-			println("zero")
-			b.start = time.Now()
-		}
+	b.clicks++
+	if b.clicks >= 2 {
+		b.stopClick()
+		b.clicks = 0
+		b.emit(DoubleClick, held)
 		return
 	}
-	if rising {
-		diff = time.Since(b.start)
+	if b.config.DoubleClick <= 0 {
+		b.clicks = 0
+		b.emit(Click, held)
+		return
 	}
-	b.status = rising
-	b.start = time.Now()
-	if diff < 10*time.Millisecond {
-		fmt.Printf("diff %v\n", diff)
+	b.clickDur = held
+	b.clickT = time.AfterFunc(b.config.DoubleClick, b.flushClick)
+}
+
+// flushClick emits the pending single click, if any.
+func (b *Button) flushClick() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.clicks == 0 || !b.status {
 		return
 	}
-	if !b.hadFirstPress {
-		b.hadFirstPress = true
+	b.clicks = 0
+	b.emit(Click, b.clickDur)
+}
+
+func (b *Button) longPress() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// The timer may race a release and a new press.
+	if b.status || b.held || time.Since(b.start) < b.config.LongPress {
 		return
 	}
-	b.Event <- ButtonEvent{
-		Status:   rising,
-		Duration: diff,
+	b.held = true
+	if b.clicks > 0 {
+		// The click before this press was never followed by a second one.
+		b.clicks = 0
+		b.emit(Click, b.clickDur)
+	}
+	b.emit(LongPress, time.Since(b.start))
+	if b.config.HoldRepeat > 0 {
+		t := time.NewTicker(b.config.HoldRepeat)
+		done := make(chan struct{})
+		b.repeatT = t
+		b.holdDone = done
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-t.C:
+				}
+				b.mu.Lock()
+				if b.repeatT != t {
+					b.mu.Unlock()
+					return
+				}
+				b.emit(HoldRepeat, time.Since(b.start))
+				b.mu.Unlock()
+			}
+		}()
+	}
+}
+
+// stopHold cancels the long press and repeat timers. Callers must hold b.mu.
+func (b *Button) stopHold() {
+	if b.longT != nil {
+		b.longT.Stop()
+		b.longT = nil
+	}
+	if b.repeatT != nil {
+		b.repeatT.Stop()
+		b.repeatT = nil
+		// Stop does not close the ticker's channel.
+		close(b.holdDone)
+		b.holdDone = nil
 	}
 }
 
-// WatchButton initializes the periph.io host and returns a channel
-// that delivers gpio.Edge events for a specified pin.
-// The pin is configured as an input with a pull-down resistor.
-func (io *IO) WatchButton(lineOffset int) (*Button, error) {
-	// Open the GPIO line
+// stopClick cancels a pending Click. Callers must hold b.mu.
+func (b *Button) stopClick() {
+	if b.clickT != nil {
+		b.clickT.Stop()
+		b.clickT = nil
+	}
+}
+
+// emit queues an event for every subscriber without blocking. Callers must
+// hold b.mu.
+func (b *Button) emit(g Gesture, d time.Duration) {
+	b.hub.publish(ButtonEvent{
+		Gesture:  g,
+		Status:   b.status,
+		Duration: d,
+		Time:     time.Now(),
+	})
+}
+
+// Subscribe registers a new consumer. Events emitted while nobody is
+// subscribed are discarded.
+func (b *Button) Subscribe() *Subscription {
+	return b.hub.subscribe()
+}
+
+// Dropped returns how many events were lost to overflow across all
+// subscribers.
+func (b *Button) Dropped() uint64 {
+	return b.hub.dropped.Load()
+}
+
+// Pull is the bias on a button line. With PullUp the button shorts the line
+// to ground, with PullDown to 3.3V.
+type Pull string
+
+const (
+	PullUp   Pull = "up"
+	PullDown Pull = "down"
+)
 
-	b := Button{
-		Event: make(chan ButtonEvent),
+func (p Pull) Validate() error {
+	switch p {
+	case PullUp, PullDown:
+		return nil
+	}
+	return fmt.Errorf("unknown pull: %q", p)
+}
+
+// WatchButton requests a GPIO line with both edges and turns its
+// transitions into gesture events.
+func (io *IO) WatchButton(lineOffset int, pull Pull, config ButtonConfig) (*Button, error) {
+	b := newButton(config)
+	bias := gpiocdev.WithPullUp
+	if pull == PullDown {
+		bias = gpiocdev.WithPullDown
+		b.active = true
 	}
 	line, err := io.chip.RequestLine(lineOffset,
-		gpiocdev.WithPullUp,
+		bias,
 		gpiocdev.WithBothEdges,
 		gpiocdev.WithEventHandler(b.eventHandler),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to request GPIO line: %w", err)
+		return nil, gpioError(fmt.Errorf("failed to request GPIO line %d: %w", lineOffset, err), ErrLineUnavailable)
+	}
+	if v, err := line.Value(); err == nil {
+		b.mu.Lock()
+		b.status = (v == 1) != b.active
+		b.mu.Unlock()
+	}
+	io.pinMu.Lock()
+	io.lines[lineOffset] = line
+	io.pinMu.Unlock()
+
+	return b, nil
+}
+
+// MotionSensorDebounce filters the chatter of a PIR output settling.
+const MotionSensorDebounce = 50 * time.Millisecond
+
+// EdgeWatcher reports a digital input going active as a Press and going
+// inactive as a Release, without any of the gestures of a Button.
+type EdgeWatcher struct {
+	mu       sync.Mutex
+	debounce time.Duration
+	status   bool // true while inactive
+	active   bool // line level while active
+	lastEdge time.Time
+	start    time.Time
+	hub      *eventHub
+}
+
+func newEdgeWatcher(debounce time.Duration) *EdgeWatcher {
+	return &EdgeWatcher{
+		debounce: debounce,
+		status:   true,
+		hub:      newEventHub(DefaultEventQueueSize, DropOldest),
+	}
+}
+
+func (e *EdgeWatcher) eventHandler(evt gpiocdev.LineEvent) {
+	rising := evt.Type != gpiocdev.LineEventFallingEdge
+	e.handleEdge(rising != e.active)
+}
+
+func (e *EdgeWatcher) handleEdge(inactive bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	if e.status == inactive {
+		return
+	}
+	if !e.lastEdge.IsZero() && now.Sub(e.lastEdge) < e.debounce {
+		return
+	}
+	e.lastEdge = now
+	e.status = inactive
+	g, d := Press, time.Duration(0)
+	if inactive {
+		g, d = Release, now.Sub(e.start)
+	} else {
+		e.start = now
+	}
+	e.hub.publish(ButtonEvent{
+		Gesture:  g,
+		Status:   inactive,
+		Duration: d,
+		Time:     now,
+	})
+}
+
+// Subscribe registers a new consumer. Events emitted while nobody is
+// subscribed are discarded.
+func (e *EdgeWatcher) Subscribe() *Subscription {
+	return e.hub.subscribe()
+}
+
+// Dropped returns how many events were lost to overflow across all
+// subscribers.
+func (e *EdgeWatcher) Dropped() uint64 {
+	return e.hub.dropped.Load()
+}
+
+// WatchMotionSensor watches the output of a PIR sensor, which drives its
+// line high while it sees movement. Motion starting is reported as a Press
+// and ending as a Release.
+func (io *IO) WatchMotionSensor(lineOffset int) (*EdgeWatcher, error) {
+	e := newEdgeWatcher(MotionSensorDebounce)
+	e.active = true
+	line, err := io.chip.RequestLine(lineOffset,
+		gpiocdev.WithPullDown,
+		gpiocdev.WithBothEdges,
+		gpiocdev.WithEventHandler(e.eventHandler),
+	)
+	if err != nil {
+		return nil, gpioError(fmt.Errorf("failed to request GPIO line %d: %w", lineOffset, err), ErrLineUnavailable)
+	}
+	if v, err := line.Value(); err == nil {
+		e.mu.Lock()
+		e.status = (v == 1) != e.active
+		e.mu.Unlock()
 	}
+	io.pinMu.Lock()
 	io.lines[lineOffset] = line
-	// Close line when context is done
+	io.pinMu.Unlock()
 
-	return &b, nil
+	return e, nil
 }
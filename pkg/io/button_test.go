@@ -0,0 +1,32 @@
+package io
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestButtonHoldRepeatStopsOnRelease(t *testing.T) {
+	config := DefaultButtonConfig()
+	config.Debounce = 0
+	config.DoubleClick = 0
+	config.LongPress = 5 * time.Millisecond
+	config.HoldRepeat = time.Millisecond
+	b := newButton(config)
+	sub := b.Subscribe()
+	defer sub.Close()
+
+	before := runtime.NumGoroutine()
+	for range 20 {
+		b.handleEdge(false)
+		for e := range sub.Events() {
+			if e.Gesture == HoldRepeat {
+				break
+			}
+		}
+		b.handleEdge(true)
+	}
+	waitFor(t, "hold repeat goroutines to exit", func() bool {
+		return runtime.NumGoroutine() <= before
+	})
+}
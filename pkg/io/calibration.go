@@ -0,0 +1,65 @@
+package io
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Calibration describes how a servo channel maps angles onto PWM ticks.
+// Pulses are 12-bit PCA9685 ticks, 1/4096 of the period set by
+// PCA9685Config.Frequency.
+type Calibration struct {
+	MinPulse   float64 `json:"minPulse"`   // ticks at 0°
+	MaxPulse   float64 `json:"maxPulse"`   // ticks at the end of Range
+	CenterTrim float64 `json:"centerTrim"` // degrees added to every commanded angle
+	Invert     bool    `json:"invert"`     // mirror the direction of travel
+	Range      float64 `json:"range"`      // mechanical travel in degrees
+	// Dither keeps alternating between the two ticks around a fractional
+	// pulse, picking one again every few PWM periods while the servo holds
+	// still, so that on average it sits between them.
+	Dither bool `json:"dither"`
+}
+
+// DefaultCalibration matches the values the servos were originally tuned
+// with, at the PCA9685's power-on frequency.
+func DefaultCalibration() Calibration {
+	return Calibration{
+		MinPulse: 255,
+		MaxPulse: 2048,
+		Range:    180,
+	}
+}
+
+func (c Calibration) Validate() error {
+	if c.MinPulse < 0 || c.MaxPulse > 4095 {
+		return fmt.Errorf("pulse range %v-%v outside 0-4095", c.MinPulse, c.MaxPulse)
+	}
+	if c.MinPulse >= c.MaxPulse {
+		return fmt.Errorf("min pulse %v must be below max pulse %v", c.MinPulse, c.MaxPulse)
+	}
+	if c.Range <= 0 {
+		return fmt.Errorf("travel range must be positive, got %v", c.Range)
+	}
+	return nil
+}
+
+// Pulse converts an angle in degrees to a PWM tick count.
+func (c Calibration) Pulse(angle float64) float64 {
+	a := math.Max(0, math.Min(c.Range, angle+c.CenterTrim))
+	if c.Invert {
+		a = c.Range - a
+	}
+	return c.MinPulse + (c.MaxPulse-c.MinPulse)*a/c.Range
+}
+
+// Ticks converts an angle to the whole tick count sent to the PCA9685,
+// rounding to the nearest tick or, with Dither, picking the tick above with
+// a probability equal to the fractional part. Each call picks again.
+func (c Calibration) Ticks(angle float64) uint16 {
+	p := c.Pulse(angle)
+	if c.Dither {
+		return uint16(math.Floor(p + rand.Float64()))
+	}
+	return uint16(math.Round(p))
+}
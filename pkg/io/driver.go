@@ -0,0 +1,59 @@
+package io
+
+import "time"
+
+// ServoDriver positions the pan/tilt servos. Angles are in degrees.
+// Moves return how long the servos need to reach the commanded angles.
+type ServoDriver interface {
+	SetServoAngle(channel int, angle float64) (time.Duration, error)
+	SetXY(channelX, channelY int, x, y float64) (time.Duration, error)
+	// GetXY returns the last commanded angles.
+	GetXY(channelX, channelY int) (float64, float64)
+	// Position returns the estimated real angle and expected arrival time.
+	Position(channel int) (float64, time.Time)
+	Reset()
+	SetCalibration(channel int, c Calibration) error
+	SetMotionLimits(channel int, limits MotionLimits) error
+}
+
+// PinDriver drives digital output lines such as the laser.
+type PinDriver interface {
+	SetPinState(pinName int, state int) error
+}
+
+// InactiveLeveler is implemented by pin drivers that can start and leave a
+// line at the level that switches it off.
+type InactiveLeveler interface {
+	SetInactiveLevel(pinName int, level int)
+}
+
+// ButtonSource delivers events for a single physical button or sensor to any
+// number of subscribers.
+type ButtonSource interface {
+	Subscribe() *Subscription
+	Dropped() uint64
+}
+
+// FaultReporter is implemented by drivers that can run with some of their
+// hardware missing.
+type FaultReporter interface {
+	// Faults returns the unavailable subsystems and why.
+	Faults() map[string]error
+}
+
+// FaultCounter is implemented by drivers that count hardware failures and
+// recover from them.
+type FaultCounter interface {
+	FaultStats() map[string]FaultStats
+}
+
+var (
+	_ ServoDriver     = (*IO)(nil)
+	_ FaultReporter   = (*IO)(nil)
+	_ FaultCounter    = (*IO)(nil)
+	_ PinDriver       = (*IO)(nil)
+	_ InactiveLeveler = (*IO)(nil)
+	_ InactiveLeveler = (*SimPins)(nil)
+	_ ButtonSource    = (*Button)(nil)
+	_ ButtonSource    = (*EdgeWatcher)(nil)
+)
@@ -0,0 +1,71 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Subsystems reported in a HardwareError.
+const (
+	SubsystemGPIO  = "gpio"
+	SubsystemPWM   = "pwm"
+	SubsystemGalvo = "galvo"
+	// SubsystemStepper is suffixed with the channel of the axis.
+	SubsystemStepper = "stepper"
+)
+
+// Kinds of hardware failure, matched with errors.Is.
+var (
+	ErrNoChip          = errors.New("gpio chip not found")
+	ErrI2CDisabled     = errors.New("i2c bus not found, is I2C enabled?")
+	ErrPWMDisabled     = errors.New("pwm chip not found, is the pwm overlay enabled?")
+	ErrPermission      = errors.New("permission denied, is the user in the gpio/i2c group?")
+	ErrDeviceNotFound  = errors.New("device did not respond")
+	ErrLineUnavailable = errors.New("gpio line unavailable")
+	ErrNotReady        = errors.New("device not ready")
+	ErrChipReset       = errors.New("device lost its configuration, was it browned out?")
+)
+
+// HardwareError is a failure setting up or talking to a piece of hardware.
+// It matches both its Kind and the underlying error with errors.Is.
+type HardwareError struct {
+	Subsystem string
+	Kind      error
+	Err       error
+}
+
+func (e *HardwareError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s: %s", e.Subsystem, e.Kind)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Subsystem, e.Kind, e.Err)
+}
+
+func (e *HardwareError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// gpioError classifies a failure opening the chip or one of its lines,
+// falling back to kind.
+func gpioError(err error, kind error) error {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		kind = ErrPermission
+	case kind == ErrNoChip && !errors.Is(err, fs.ErrNotExist):
+		kind = ErrNotReady
+	}
+	return &HardwareError{Subsystem: SubsystemGPIO, Kind: kind, Err: err}
+}
+
+// i2cError classifies a failure reaching a device on the I2C bus.
+func i2cError(subsystem string, err error) error {
+	kind := ErrDeviceNotFound
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		kind = ErrPermission
+	case errors.Is(err, fs.ErrNotExist):
+		kind = ErrI2CDisabled
+	}
+	return &HardwareError{Subsystem: subsystem, Kind: kind, Err: err}
+}
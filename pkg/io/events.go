@@ -0,0 +1,127 @@
+package io
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy decides what happens when a subscriber's queue is full.
+type OverflowPolicy string
+
+const (
+	// DropOldest discards the oldest queued event to make room.
+	DropOldest OverflowPolicy = "dropOldest"
+	// Coalesce collapses the whole backlog into the newest event.
+	Coalesce OverflowPolicy = "coalesce"
+)
+
+const DefaultEventQueueSize = 16
+
+// Subscription is one consumer's bounded queue of button events.
+type Subscription struct {
+	ch      chan ButtonEvent
+	dropped atomic.Uint64
+	hub     *eventHub
+}
+
+// Events returns the channel queued events are read from.
+func (s *Subscription) Events() <-chan ButtonEvent {
+	return s.ch
+}
+
+// Dropped returns how many events this subscriber lost to overflow.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops delivery to the subscription.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// eventHub fans events out to subscribers without ever blocking the
+// publisher, which runs on the GPIO event goroutine.
+type eventHub struct {
+	mu      sync.Mutex
+	size    int
+	policy  OverflowPolicy
+	subs    map[*Subscription]struct{}
+	dropped atomic.Uint64
+}
+
+func newEventHub(size int, policy OverflowPolicy) *eventHub {
+	if size <= 0 {
+		size = DefaultEventQueueSize
+	}
+	if policy == "" {
+		policy = DropOldest
+	}
+	return &eventHub{
+		size:   size,
+		policy: policy,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *eventHub) subscribe() *Subscription {
+	s := &Subscription{
+		ch:  make(chan ButtonEvent, h.size),
+		hub: h,
+	}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+func (h *eventHub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+func (h *eventHub) publish(e ButtonEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		h.deliver(s, e)
+	}
+}
+
+// deliver queues e for s, making room according to the policy. Only the
+// publisher sends on s.ch, so once room is made the send cannot block.
+func (h *eventHub) deliver(s *Subscription, e ButtonEvent) {
+	for {
+		select {
+		case s.ch <- e:
+			return
+		default:
+		}
+		switch h.policy {
+		case Coalesce:
+			for len(s.ch) > 0 {
+				h.drop(s)
+			}
+		default:
+			h.drop(s)
+		}
+	}
+}
+
+func (h *eventHub) drop(s *Subscription) {
+	select {
+	case <-s.ch:
+		s.dropped.Add(1)
+		h.dropped.Add(1)
+	default:
+	}
+}
+
+func (p OverflowPolicy) Validate() error {
+	switch p {
+	case DropOldest, Coalesce, "":
+		return nil
+	}
+	return fmt.Errorf("unknown overflow policy: %s", p)
+}
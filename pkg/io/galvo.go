@@ -0,0 +1,408 @@
+package io
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/host/v3"
+)
+
+const (
+	DACMCP4725 = "mcp4725" // one single-channel DAC per axis
+	DACMCP4728 = "mcp4728" // one quad DAC, X on channel A and Y on B
+)
+
+// I2CBus is the part of an I2C bus the galvo DACs need. periph buses
+// implement it.
+type I2CBus interface {
+	Tx(addr uint16, w, r []byte) error
+}
+
+// Point is a galvo position in degrees, on the same 0-180 scale as servos.
+type Point struct {
+	X, Y float64
+}
+
+// PointStreamer plays precomputed paths at its own fixed update rate
+// instead of being commanded and waited on one step at a time.
+type PointStreamer interface {
+	// Stream queues points, each held for one update, and returns when the
+	// last one will have been written.
+	Stream(points []Point) (time.Duration, error)
+}
+
+// GalvoConfig describes a pair of galvo mirrors driven by an I2C DAC.
+type GalvoConfig struct {
+	DAC      string `json:"dac"` // "mcp4725" or "mcp4728"
+	Bus      int    `json:"bus"`
+	AddressX int    `json:"addressX"` // the MCP4728, or the X MCP4725
+	AddressY int    `json:"addressY"` // the Y MCP4725
+	// Rate is DAC updates per second. At 400kHz I2C an update takes about
+	// 100µs for an MCP4728 and twice that for a MCP4725 pair.
+	Rate float64 `json:"rate"`
+	// Range is the span of degrees mapped onto the DAC's full scale,
+	// centered on 90°.
+	Range   float64 `json:"range"`
+	InvertX bool    `json:"invertX"`
+	InvertY bool    `json:"invertY"`
+	// Limits is how fast SetXY sweeps the mirrors between commands.
+	Limits MotionLimits `json:"limits"`
+}
+
+func DefaultGalvoConfig() GalvoConfig {
+	return GalvoConfig{
+		DAC:      DACMCP4728,
+		Bus:      1,
+		AddressX: 0x60,
+		AddressY: 0x61,
+		Rate:     2000,
+		Range:    180,
+		Limits:   MotionLimits{Speed: 10 * time.Millisecond},
+	}
+}
+
+// UnmarshalJSON fills the fields a config leaves out from
+// DefaultGalvoConfig.
+func (c *GalvoConfig) UnmarshalJSON(b []byte) error {
+	type plain GalvoConfig
+	p := plain(DefaultGalvoConfig())
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*c = GalvoConfig(p)
+	return nil
+}
+
+func (c GalvoConfig) Validate() error {
+	switch c.DAC {
+	case DACMCP4725, DACMCP4728:
+	default:
+		return fmt.Errorf("unknown galvo DAC %q", c.DAC)
+	}
+	if c.Rate <= 0 || c.Range <= 0 {
+		return fmt.Errorf("galvo rate and range must be positive, got %v and %v", c.Rate, c.Range)
+	}
+	if c.DAC == DACMCP4725 && c.AddressX == c.AddressY {
+		return fmt.Errorf("galvo MCP4725s need different addresses, both are 0x%02x", c.AddressX)
+	}
+	return c.Limits.Validate()
+}
+
+// Galvo streams X/Y positions to the DAC at a fixed rate. Queued points
+// are written one per update; with nothing queued the mirrors hold still.
+type Galvo struct {
+	config         GalvoConfig
+	bus            I2CBus
+	period         time.Duration
+	motorX, motorY int
+	next           ServoDriver
+	closeBus       func() error
+
+	mu       sync.Mutex
+	queue    []Point
+	current  Point // last point written
+	target   Point // last point queued
+	failures uint64
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{} // closed when run returns
+}
+
+// NewGalvo starts streaming to the DAC on bus. The galvo answers to the
+// motorX and motorY channels of the ServoDriver interface; other channels
+// are passed on to next, which may be nil.
+func NewGalvo(config GalvoConfig, bus I2CBus, motorX, motorY int, next ServoDriver) (*Galvo, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	g := &Galvo{
+		config: config,
+		bus:    bus,
+		period: time.Duration(float64(time.Second) / config.Rate),
+		motorX: motorX,
+		motorY: motorY,
+		next:   next,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	g.current = Point{90, 90}
+	g.target = g.current
+	if err := g.write(g.current); err != nil {
+		return nil, i2cError(SubsystemGalvo, fmt.Errorf("failed writing galvo DAC: %w", err))
+	}
+	go g.run()
+	return g, nil
+}
+
+// OpenGalvo opens the I2C bus in config with periph and starts a Galvo on
+// it.
+func OpenGalvo(config GalvoConfig, motorX, motorY int, next ServoDriver) (*Galvo, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, i2cError(SubsystemGalvo, fmt.Errorf("failed to initialize periph host: %w", err))
+	}
+	bus, err := i2creg.Open(fmt.Sprintf("I2C%d", config.Bus))
+	if err != nil {
+		return nil, &HardwareError{Subsystem: SubsystemGalvo, Kind: ErrI2CDisabled, Err: err}
+	}
+	g, err := NewGalvo(config, bus, motorX, motorY, next)
+	if err != nil {
+		_ = bus.Close()
+		return nil, err
+	}
+	g.closeBus = bus.Close
+	return g, nil
+}
+
+// code maps an angle onto a 12-bit DAC word.
+func (g *Galvo) code(angle float64, invert bool) uint16 {
+	v := 0.5 + (angle-90)/g.config.Range
+	if invert {
+		v = 1 - v
+	}
+	return uint16(math.Round(math.Max(0, math.Min(1, v)) * 4095))
+}
+
+// write sends one point using the DACs' fast write commands, power-down
+// bits cleared. The MCP4728 needs LDAC tied low to update immediately.
+func (g *Galvo) write(p Point) error {
+	x := g.code(p.X, g.config.InvertX)
+	y := g.code(p.Y, g.config.InvertY)
+	if g.config.DAC == DACMCP4728 {
+		return g.bus.Tx(uint16(g.config.AddressX), []byte{byte(x >> 8), byte(x), byte(y >> 8), byte(y)}, nil)
+	}
+	if err := g.bus.Tx(uint16(g.config.AddressX), []byte{byte(x >> 8), byte(x)}, nil); err != nil {
+		return err
+	}
+	return g.bus.Tx(uint16(g.config.AddressY), []byte{byte(y >> 8), byte(y)}, nil)
+}
+
+// run writes queued points on a fixed schedule. Like the stepper it keeps
+// to the schedule in bursts when timers are coarser than the period.
+func (g *Galvo) run() {
+	defer close(g.done)
+	due := time.Now()
+	for {
+		g.mu.Lock()
+		if len(g.queue) == 0 {
+			g.mu.Unlock()
+			select {
+			case <-g.stop:
+				return
+			case <-g.wake:
+			}
+			due = time.Now()
+			continue
+		}
+		p := g.queue[0]
+		g.queue = g.queue[1:]
+		g.mu.Unlock()
+
+		err := g.write(p)
+		g.mu.Lock()
+		if err != nil {
+			if g.failures == 0 {
+				log.Printf("galvo DAC write failed: %s", err)
+			}
+			g.failures++
+		} else {
+			g.current = p
+		}
+		g.mu.Unlock()
+
+		due = due.Add(g.period)
+		if wait := time.Until(due); wait > time.Millisecond {
+			select {
+			case <-g.stop:
+				return
+			case <-time.After(wait):
+			}
+		} else {
+			select {
+			case <-g.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Stream queues points after any still waiting to be written.
+func (g *Galvo) Stream(points []Point) (time.Duration, error) {
+	if len(points) == 0 {
+		return 0, nil
+	}
+	g.mu.Lock()
+	g.queue = append(g.queue, points...)
+	g.target = points[len(points)-1]
+	d := time.Duration(len(g.queue)) * g.period
+	g.mu.Unlock()
+	select {
+	case g.wake <- struct{}{}:
+	default:
+	}
+	return d, nil
+}
+
+// sweep returns the points from the last queued target to to, spaced so
+// the mirrors move at the configured speed. Callers must hold g.mu.
+func (g *Galvo) sweep(to Point) []Point {
+	from := g.target
+	step := g.config.Limits.velocity() / g.config.Rate
+	n := int(math.Ceil(math.Hypot(to.X-from.X, to.Y-from.Y) / step))
+	points := make([]Point, 0, max(n, 1))
+	for i := 1; i <= n; i++ {
+		p := float64(i) / float64(n)
+		points = append(points, Point{from.X + (to.X-from.X)*p, from.Y + (to.Y-from.Y)*p})
+	}
+	if n == 0 {
+		points = append(points, to)
+	}
+	return points
+}
+
+func (g *Galvo) SetXY(channelX, channelY int, x, y float64) (time.Duration, error) {
+	if channelX != g.motorX || channelY != g.motorY {
+		if g.next == nil {
+			return 0, fmt.Errorf("no galvo or servo on channels %d/%d", channelX, channelY)
+		}
+		return g.next.SetXY(channelX, channelY, x, y)
+	}
+	g.mu.Lock()
+	points := g.sweep(Point{x, y})
+	g.mu.Unlock()
+	return g.Stream(points)
+}
+
+func (g *Galvo) SetServoAngle(channel int, angle float64) (time.Duration, error) {
+	g.mu.Lock()
+	x, y := g.target.X, g.target.Y
+	g.mu.Unlock()
+	switch channel {
+	case g.motorX:
+		return g.SetXY(g.motorX, g.motorY, angle, y)
+	case g.motorY:
+		return g.SetXY(g.motorX, g.motorY, x, angle)
+	}
+	if g.next == nil {
+		return 0, fmt.Errorf("no galvo or servo on channel %d", channel)
+	}
+	return g.next.SetServoAngle(channel, angle)
+}
+
+// GetXY returns the last queued position, like IO.GetXY returns the last
+// commanded angles.
+func (g *Galvo) GetXY(channelX, channelY int) (float64, float64) {
+	if channelX != g.motorX || channelY != g.motorY {
+		if g.next == nil {
+			return 0, 0
+		}
+		return g.next.GetXY(channelX, channelY)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.target.X, g.target.Y
+}
+
+// Position returns the last written angle of a mirror and when the queue
+// will have drained.
+func (g *Galvo) Position(channel int) (float64, time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	arrival := time.Now().Add(time.Duration(len(g.queue)) * g.period)
+	switch channel {
+	case g.motorX:
+		return g.current.X, arrival
+	case g.motorY:
+		return g.current.Y, arrival
+	}
+	if g.next == nil {
+		return 0, time.Now()
+	}
+	return g.next.Position(channel)
+}
+
+func (g *Galvo) Reset() {
+	_, _ = g.SetXY(g.motorX, g.motorY, 90, 90)
+	if g.next != nil {
+		g.next.Reset()
+	}
+}
+
+// SetCalibration passes servo calibrations on; the mirrors are calibrated
+// by Range and Invert.
+func (g *Galvo) SetCalibration(channel int, c Calibration) error {
+	if (channel == g.motorX || channel == g.motorY) || g.next == nil {
+		return c.Validate()
+	}
+	return g.next.SetCalibration(channel, c)
+}
+
+func (g *Galvo) SetMotionLimits(channel int, limits MotionLimits) error {
+	if (channel == g.motorX || channel == g.motorY) || g.next == nil {
+		if err := limits.Validate(); err != nil {
+			return err
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.config.Limits = limits
+		return nil
+	}
+	return g.next.SetMotionLimits(channel, limits)
+}
+
+// Faults reports failed DAC writes along with the faults of next.
+func (g *Galvo) Faults() map[string]error {
+	faults := map[string]error{}
+	if r, ok := g.next.(FaultReporter); ok {
+		faults = r.Faults()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.failures > 0 {
+		faults[SubsystemGalvo] = fmt.Errorf("%d DAC writes failed", g.failures)
+	}
+	return faults
+}
+
+// FaultStats counts failed DAC writes along with the failures of next.
+func (g *Galvo) FaultStats() map[string]FaultStats {
+	stats := map[string]FaultStats{}
+	if c, ok := g.next.(FaultCounter); ok {
+		stats = c.FaultStats()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	stats[SubsystemGalvo] = FaultStats{Faults: g.failures}
+	return stats
+}
+
+// Close stops streaming, leaving the mirrors where they are. It waits for
+// the write in flight so the bus is not closed under it.
+func (g *Galvo) Close() {
+	g.mu.Lock()
+	select {
+	case <-g.stop:
+		g.mu.Unlock()
+		return
+	default:
+		close(g.stop)
+	}
+	g.mu.Unlock()
+	<-g.done
+	if g.closeBus != nil {
+		_ = g.closeBus()
+	}
+}
+
+var (
+	_ ServoDriver   = (*Galvo)(nil)
+	_ PointStreamer = (*Galvo)(nil)
+	_ FaultReporter = (*Galvo)(nil)
+	_ FaultCounter  = (*Galvo)(nil)
+)
@@ -0,0 +1,161 @@
+package io
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// i2cWrite is a write recorded by fakeI2CBus.
+type i2cWrite struct {
+	addr uint16
+	data []byte
+	at   time.Time
+}
+
+// fakeI2CBus records every write instead of talking to hardware.
+type fakeI2CBus struct {
+	mu     sync.Mutex
+	writes []i2cWrite
+	closed bool
+}
+
+func (f *fakeI2CBus) Tx(addr uint16, w, r []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		panic("write on a closed bus")
+	}
+	f.writes = append(f.writes, i2cWrite{addr: addr, data: append([]byte(nil), w...), at: time.Now()})
+	return nil
+}
+
+func (f *fakeI2CBus) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+func (f *fakeI2CBus) Writes() []i2cWrite {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]i2cWrite(nil), f.writes...)
+}
+
+func TestGalvoCode(t *testing.T) {
+	g := &Galvo{config: DefaultGalvoConfig()}
+	for _, c := range []struct {
+		angle  float64
+		invert bool
+		want   uint16
+	}{
+		{0, false, 0},
+		{90, false, 2048},
+		{180, false, 4095},
+		{45, false, 1024},
+		{0, true, 4095},
+		{180, true, 0},
+		{-20, false, 0},
+		{200, false, 4095},
+	} {
+		if got := g.code(c.angle, c.invert); got != c.want {
+			t.Errorf("code(%v, %v) = %d, want %d", c.angle, c.invert, got, c.want)
+		}
+	}
+	g.config.Range = 40
+	if got := g.code(110, false); got != 4095 {
+		t.Errorf("code(110) over a 40° range = %d, want full scale", got)
+	}
+	if got := g.code(80, false); got != 1024 {
+		t.Errorf("code(80) over a 40° range = %d, want 1024", got)
+	}
+}
+
+func TestGalvoWrite(t *testing.T) {
+	for _, c := range []struct {
+		dac  string
+		want []i2cWrite
+	}{
+		{DACMCP4728, []i2cWrite{{addr: 0x60, data: []byte{0x04, 0x00, 0x0f, 0xff}}}},
+		{DACMCP4725, []i2cWrite{{addr: 0x60, data: []byte{0x04, 0x00}}, {addr: 0x61, data: []byte{0x0f, 0xff}}}},
+	} {
+		bus := &fakeI2CBus{}
+		config := DefaultGalvoConfig()
+		config.DAC = c.dac
+		g := &Galvo{config: config, bus: bus}
+		if err := g.write(Point{X: 45, Y: 180}); err != nil {
+			t.Fatalf("%s: %v", c.dac, err)
+		}
+		writes := bus.Writes()
+		if len(writes) != len(c.want) {
+			t.Fatalf("%s: got %d writes, want %d", c.dac, len(writes), len(c.want))
+		}
+		for i, w := range writes {
+			if w.addr != c.want[i].addr || !bytes.Equal(w.data, c.want[i].data) {
+				t.Errorf("%s: write %d = 0x%02x % x, want 0x%02x % x", c.dac, i, w.addr, w.data, c.want[i].addr, c.want[i].data)
+			}
+		}
+	}
+}
+
+func TestGalvoStream(t *testing.T) {
+	bus := &fakeI2CBus{}
+	config := DefaultGalvoConfig()
+	config.Rate = 500
+	g, err := NewGalvo(config, bus, 0, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.closeBus = bus.Close
+	points := make([]Point, 50)
+	for i := range points {
+		points[i] = Point{X: float64(i), Y: 180 - float64(i)}
+	}
+	start := time.Now()
+	d, err := g.Stream(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != 100*time.Millisecond {
+		t.Errorf("Stream of 50 points at 500Hz returned %v, want 100ms", d)
+	}
+	waitFor(t, "the queue to drain", func() bool { return len(bus.Writes()) == 51 })
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("50 points at 500Hz were written in %v, want about 100ms", elapsed)
+	}
+	writes := bus.Writes()[1:] // the first centers the mirrors
+	for i, w := range writes {
+		x, y := g.code(points[i].X, false), g.code(points[i].Y, false)
+		want := []byte{byte(x >> 8), byte(x), byte(y >> 8), byte(y)}
+		if !bytes.Equal(w.data, want) {
+			t.Errorf("point %d wrote % x, want % x", i, w.data, want)
+		}
+	}
+	if x, y := g.GetXY(0, 1); x != 49 || y != 131 {
+		t.Errorf("GetXY = %v, %v, want the last streamed point 49, 131", x, y)
+	}
+
+	g.Stream(points)
+	g.Close()
+	n := len(bus.Writes())
+	time.Sleep(20 * time.Millisecond)
+	if got := len(bus.Writes()); got != n {
+		t.Errorf("%d writes after Close", got-n)
+	}
+}
+
+func TestGalvoConfigDefaults(t *testing.T) {
+	var c *GalvoConfig
+	if err := json.Unmarshal([]byte(`{"dac": "mcp4725", "addressY": 98}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("galvo config without limits does not validate: %s", err)
+	}
+	if c.AddressX != 0x60 || c.AddressY != 98 || c.Rate != 2000 {
+		t.Errorf("got addresses 0x%02x/%d at %v updates/s, want the default X address and rate with the configured Y", c.AddressX, c.AddressY, c.Rate)
+	}
+}
@@ -0,0 +1,139 @@
+package io
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// IdleConfig controls when idle servos are detached.
+type IdleConfig struct {
+	// Timeout after the last command before a channel's PWM output is
+	// switched fully off. 0 keeps the servos powered forever. The
+	// controller keeps commanding a turret whose laser is on, however long
+	// it pauses.
+	Timeout time.Duration `json:"timeout"`
+	// PowerLine is a GPIO line driving a relay or MOSFET on the servo supply,
+	// cut once every servo is idle. -1 when there is none.
+	PowerLine      int           `json:"powerLine"`
+	PowerActiveLow bool          `json:"powerActiveLow"`
+	PowerSettle    time.Duration `json:"powerSettle"` // wait after restoring power before commanding
+}
+
+func DefaultIdleConfig() IdleConfig {
+	return IdleConfig{
+		Timeout:     30 * time.Second,
+		PowerLine:   -1,
+		PowerSettle: 100 * time.Millisecond,
+	}
+}
+
+// SetIdle enables idle detaching. It replaces any earlier configuration.
+func (io *IO) SetIdle(config IdleConfig) error {
+	if config.Timeout < 0 {
+		return fmt.Errorf("idle timeout must not be negative, got %v", config.Timeout)
+	}
+	io.mu.Lock()
+	if io.idleStop != nil {
+		close(io.idleStop)
+		io.idleStop = nil
+	}
+	io.idle = config
+	if config.PowerLine >= 0 && config.PowerActiveLow {
+		io.SetInactiveLevel(config.PowerLine, 1)
+	}
+	if err := io.setPower(true); err != nil {
+		io.mu.Unlock()
+		return err
+	}
+	if config.Timeout > 0 {
+		io.idleStop = make(chan struct{})
+		go io.watchIdle(config.Timeout, io.idleStop)
+	}
+	io.mu.Unlock()
+	return nil
+}
+
+func (io *IO) watchIdle(timeout time.Duration, stop chan struct{}) {
+	interval := min(timeout/4, time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		io.mu.Lock()
+		io.detachIdle(timeout, time.Now())
+		io.mu.Unlock()
+	}
+}
+
+// detachIdle switches off channels that have not been commanded within
+// timeout, and the servo supply once all of them are. Callers must hold
+// io.mu.
+func (io *IO) detachIdle(timeout time.Duration, now time.Time) {
+	if io.servos == nil {
+		return
+	}
+	attached := 0
+	for ch, m := range io.motorAngle {
+		if m.detached {
+			continue
+		}
+		if now.Sub(m.lastCommand) < timeout {
+			attached++
+			continue
+		}
+		if err := io.writePWM(ch, 0, 4096); err != nil {
+			log.Printf("failed detaching servo %d: %s", ch, err)
+			attached++
+			continue
+		}
+		m.detached = true
+	}
+	if attached == 0 && io.powered && len(io.motorAngle) > 0 {
+		if err := io.setPower(false); err != nil {
+			log.Printf("failed cutting servo power: %s", err)
+		}
+	}
+}
+
+// attach restores power and the last pulse of a detached channel so the
+// next move starts from where the servo was left. Callers must hold io.mu.
+func (io *IO) attach(channel int, m *MotorInfo) error {
+	m.lastCommand = time.Now()
+	if !m.detached {
+		return nil
+	}
+	if !io.powered {
+		if err := io.setPower(true); err != nil {
+			return err
+		}
+		time.Sleep(io.idle.PowerSettle)
+	}
+	if err := io.writePWM(channel, 0, m.LastPulse); err != nil {
+		return err
+	}
+	m.detached = false
+	return nil
+}
+
+// setPower switches the servo supply, if there is a power line. Callers
+// must hold io.mu.
+func (io *IO) setPower(on bool) error {
+	if io.idle.PowerLine < 0 {
+		io.powered = true
+		return nil
+	}
+	level := 0
+	if on != io.idle.PowerActiveLow {
+		level = 1
+	}
+	if err := io.SetPinState(io.idle.PowerLine, level); err != nil {
+		return fmt.Errorf("failed switching servo power: %w", err)
+	}
+	io.powered = on
+	return nil
+}
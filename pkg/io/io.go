@@ -4,129 +4,325 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/warthog618/go-gpiocdev"
-	"gobot.io/x/gobot/drivers/i2c"
-	"gobot.io/x/gobot/platforms/raspi"
 )
 
 type IO struct {
-	chip       *gpiocdev.Chip
-	buttons    []Button
-	lines      map[int]*gpiocdev.Line
-	servos     *i2c.PCA9685Driver // Add the PCA9685 driver field
-	mu         sync.Mutex
-	motorAngle map[int]*MotorInfo
+	chip        *gpiocdev.Chip
+	buttons     []Button
+	lines       map[int]*gpiocdev.Line
+	servos      PWMDriver // changed holding both mu and outMu, read holding either
+	mu          sync.Mutex
+	outMu       sync.Mutex  // guards lastPWM, never held while waiting for mu
+	pinMu       sync.Mutex  // guards lines and inactive
+	inactive    map[int]int // level that switches each output line off
+	motorAngle  map[int]*MotorInfo
+	calibration map[int]Calibration
+	limits      map[int]MotionLimits
+	idle        IdleConfig
+	idleStop    chan struct{}
+	powered     bool
+	faults      map[string]error // subsystems that are currently unavailable
+	done        chan struct{}    // closed by Close to stop reconnecting
+	pwmConfig   PCA9685Config
+	lastPWM     map[int]PWMWrite // last commanded output of each channel, replayed after a reset
+	connecting  bool             // connectPWM is running
+	pwmStats    FaultStats
 }
 type MotorInfo struct {
-	LastDelay    float64
-	CurrentAngle int
+	LastPulse    uint16
+	CurrentAngle float64
+	output       float64 // angle last written, trailing CurrentAngle while ramping
+	model        *servoModel
+	lastCommand  time.Time
+	detached     bool
 }
 
-func New(chipset string) *IO {
+// New opens the GPIO chip and the PCA9685. Only a missing chip is fatal: a
+// PCA9685 that cannot be reached yet is reported through Faults and retried
+// in the background, and servo moves fail with ErrNotReady until it shows up.
+// The same happens when the board stops answering or resets later on.
+func New(chipset string, pwm PCA9685Config) (*IO, error) {
 	c, err := gpiocdev.NewChip(chipset)
 	if err != nil {
-		fmt.Printf("Opening chip returned error: %s\n", err)
-		os.Exit(1)
+		return nil, gpioError(fmt.Errorf("opening %s: %w", chipset, err), ErrNoChip)
 	}
 	info, err := c.LineInfo(0)
 	if err != nil {
-		fmt.Printf("Reading line info returned error: %s\n", err)
-		os.Exit(1)
+		_ = c.Close()
+		return nil, gpioError(fmt.Errorf("reading line info of %s: %w", chipset, err), ErrNotReady)
 	}
 	fmt.Printf("%v\n", info)
 
-	// Initialize the PCA9685 driver
-	r := raspi.NewAdaptor()
-	servos := i2c.NewPCA9685Driver(r)
+	io := &IO{
+		chip:        c,
+		buttons:     nil,
+		lines:       make(map[int]*gpiocdev.Line),
+		inactive:    make(map[int]int),
+		motorAngle:  make(map[int]*MotorInfo),
+		calibration: make(map[int]Calibration),
+		limits:      make(map[int]MotionLimits),
+		idle:        IdleConfig{PowerLine: -1},
+		powered:     true,
+		faults:      make(map[string]error),
+		done:        make(chan struct{}),
+		pwmConfig:   pwm,
+		lastPWM:     make(map[int]PWMWrite),
+	}
+	// io.servos is set before connectPWM can race to fill it in.
+	servos, err := NewPCA9685(pwm)
+	if err == nil {
+		io.servos = servos
+	} else {
+		log.Printf("PCA9685 unavailable, retrying in the background: %s", err)
+		io.faults[SubsystemPWM] = err
+		io.connecting = true
+		go io.connectPWM()
+	}
+	if pwm.HealthCheck > 0 {
+		go io.watchPWM(pwm.HealthCheck)
+	}
+	go io.ramp()
+	return io, nil
+}
 
-	// Start the driver
-	if err := servos.Start(); err != nil {
-		log.Fatalf("Failed to start PCA9685 driver: %v", err)
+// pwm returns the PCA9685, or an ErrNotReady HardwareError while it is
+// missing. Callers must hold io.mu.
+func (io *IO) pwm() (PWMDriver, error) {
+	if io.servos == nil {
+		return nil, &HardwareError{Subsystem: SubsystemPWM, Kind: ErrNotReady, Err: io.faults[SubsystemPWM]}
 	}
+	return io.servos, nil
+}
 
-	return &IO{
-		chip:       c,
-		buttons:    nil,
-		lines:      make(map[int]*gpiocdev.Line),
-		servos:     servos,
-		motorAngle: make(map[int]*MotorInfo),
+// Faults returns the subsystems that are currently unavailable and why.
+func (io *IO) Faults() map[string]error {
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	faults := make(map[string]error, len(io.faults))
+	for k, v := range io.faults {
+		faults[k] = v
 	}
+	return faults
 }
 
-func (io *IO) SetXY(channelX, channelY int, x, y uint8) (int, error) {
-	wg := sync.WaitGroup{}
-	var delay int
-	var i int
-	wg.Go(func() {
-		delay, _ = io.SetServoAngle(channelX, x)
+// SetCalibration replaces the pulse calibration used for a channel.
+func (io *IO) SetCalibration(channel int, c Calibration) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("channel %d: %w", channel, err)
+	}
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	io.calibration[channel] = c
+	return nil
+}
 
-	})
-	wg.Go(func() {
-		i, _ = io.SetServoAngle(channelY, y)
-	})
-	wg.Wait()
-	// get last angle+set new angle
+func (io *IO) channelCalibration(channel int) Calibration {
+	if c, ok := io.calibration[channel]; ok {
+		return c
+	}
+	return DefaultCalibration()
+}
+
+// SetMotionLimits sets the speed and acceleration used to model a channel.
+func (io *IO) SetMotionLimits(channel int, limits MotionLimits) error {
+	if err := limits.Validate(); err != nil {
+		return fmt.Errorf("channel %d: %w", channel, err)
+	}
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	io.limits[channel] = limits
+	if m, ok := io.motorAngle[channel]; ok {
+		m.model.limits = limits
+	}
+	return nil
+}
+
+func (io *IO) channelLimits(channel int) MotionLimits {
+	if l, ok := io.limits[channel]; ok {
+		return l
+	}
+	return DefaultMotionLimits()
+}
+
+// motor returns the tracked state for a channel, starting it centered.
+// Callers must hold io.mu.
+func (io *IO) motor(channel int) *MotorInfo {
+	if _, ok := io.motorAngle[channel]; !ok {
+		io.motorAngle[channel] = &MotorInfo{
+			CurrentAngle: 90,
+			output:       90,
+			LastPulse:    io.channelCalibration(channel).Ticks(90),
+			model:        newServoModel(io.channelLimits(channel), 90),
+		}
+	}
+	return io.motorAngle[channel]
+}
 
-	return i + delay, nil
+// SetXY moves both axes and returns when the slower one will arrive. Both
+// channels are written in one bus transaction when the driver supports it,
+// so they start moving in the same PWM period.
+func (io *IO) SetXY(channelX, channelY int, x, y float64) (time.Duration, error) {
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	if _, err := io.pwm(); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	var writes []PWMWrite
+	var arrive time.Duration
+	for _, axis := range []struct {
+		channel int
+		angle   float64
+	}{{channelX, x}, {channelY, y}} {
+		if axis.channel < 0 {
+			continue
+		}
+		w, d, err := io.command(axis.channel, axis.angle, now)
+		if err != nil {
+			return 0, err
+		}
+		writes = append(writes, w)
+		arrive = max(arrive, d)
+	}
+	return arrive, io.writePWMs(writes)
 }
 
 // SetServoAngle sets the angle for a specific servo channel.
-// The angle should be between 0 and 180 degrees.
-func (io *IO) SetServoAngle(channel int, angle uint8) (int, error) {
-	// Convert the 0-180 degree angle to a 12-bit PWM value (0-4095).
-	// The typical pulse width for servos is between 0.5ms and 2.5ms.
-	// With a 50Hz PWM frequency, the full cycle is 20ms (4096 ticks).
-	// So, a 0.5ms pulse is about 102 ticks, and a 2.5ms pulse is about 512 ticks.
+// The angle is converted to a 12-bit PWM value using the channel's
+// Calibration. It returns how long the servo needs to get there.
+func (io *IO) SetServoAngle(channel int, angle float64) (time.Duration, error) {
 	if channel < 0 {
 		return 0, nil
 	}
 	io.mu.Lock()
 	defer io.mu.Unlock()
-	if _, ok := io.motorAngle[channel]; !ok {
-		io.motorAngle[channel] = &MotorInfo{CurrentAngle: 90, LastDelay: 1151.5}
-	}
-	// We can use these values for our scaling.
-	minPulse := float64(255)  // Corresponds to ~0 degrees
-	maxPulse := float64(2048) // Corresponds to ~180 degrees
-	//
-	//// Scale the angle from 0-180 to the minPulse-maxPulse range
-	pulseWidth := minPulse + (maxPulse-minPulse)*float64(angle)/180.0
-	//fmt.Printf("Angle:%v : %f\n", angle, pulseWidth)
+	if _, err := io.pwm(); err != nil {
+		return 0, err
+	}
+	w, arrive, err := io.command(channel, angle, time.Now())
+	if err != nil {
+		return 0, err
+	}
 	// Set the PWM for the specified channel using the 12-bit value
-	diff := math.Abs(io.motorAngle[channel].LastDelay - pulseWidth)
-	io.motorAngle[channel].LastDelay = pulseWidth
-	io.motorAngle[channel].CurrentAngle = int(angle)
-	return int(diff), io.servos.SetPWM(channel, 0, uint16(pulseWidth))
+	return arrive, io.writePWM(w.Channel, w.On, w.Off)
 }
-func (io *IO) GetXY(channelX, channelY int) (int, int) {
-	if _, ok := io.motorAngle[channelX]; !ok {
-		io.motorAngle[channelX] = &MotorInfo{CurrentAngle: 90, LastDelay: 1151.5}
+
+// command updates the tracked state of a channel for a move to angle and
+// returns the PWM write that starts it; ramp carries on from there. Callers
+// must hold io.mu.
+func (io *IO) command(channel int, angle float64, now time.Time) (PWMWrite, time.Duration, error) {
+	m := io.motor(channel)
+	if err := io.attach(channel, m); err != nil {
+		return PWMWrite{}, 0, err
 	}
-	if _, ok := io.motorAngle[channelY]; !ok {
-		io.motorAngle[channelY] = &MotorInfo{CurrentAngle: 90, LastDelay: 1151.5}
+	angle = math.Max(0, math.Min(180, angle))
+	m.CurrentAngle = angle
+	arrive := m.model.command(angle, now)
+	return io.output(channel, m, now), arrive, nil
+}
+
+// output moves a channel's pulse to where its model says the servo should
+// be at now. Callers must hold io.mu.
+func (io *IO) output(channel int, m *MotorInfo, now time.Time) PWMWrite {
+	m.output = m.model.position(now)
+	m.LastPulse = io.channelCalibration(channel).Ticks(m.output)
+	return PWMWrite{Channel: channel, Off: m.LastPulse}
+}
+
+// ramp steps every moving channel's pulse along its modeled path until
+// Close, so a servo is never driven faster than its MotionLimits allow. It
+// also keeps dithering the channels that ask for it.
+func (io *IO) ramp() {
+	ticker := time.NewTicker(rampInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-io.done:
+			return
+		case <-ticker.C:
+		}
+		io.mu.Lock()
+		now := time.Now()
+		var writes []PWMWrite
+		for ch, m := range io.motorAngle {
+			if io.servos == nil || m.detached {
+				continue
+			}
+			if m.output != m.CurrentAngle {
+				writes = append(writes, io.output(ch, m, now))
+				continue
+			}
+			// A dithered channel keeps switching between the ticks around
+			// its pulse while it holds still.
+			if c := io.channelCalibration(ch); c.Dither {
+				if t := c.Ticks(m.output); t != m.LastPulse {
+					m.LastPulse = t
+					writes = append(writes, PWMWrite{Channel: ch, Off: t})
+				}
+			}
+		}
+		if len(writes) > 0 {
+			// A failure is handled by pwmFailed, and the next tick retries.
+			_ = io.writePWMs(writes)
+		}
+		io.mu.Unlock()
 	}
-	return io.motorAngle[channelX].CurrentAngle, io.motorAngle[channelY].CurrentAngle
+}
+
+// Position returns the modeled angle of a channel and when it is expected
+// to reach its commanded angle.
+func (io *IO) Position(channel int) (float64, time.Time) {
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	m := io.motor(channel)
+	return m.model.position(time.Now()), m.model.arrival
+}
+func (io *IO) GetXY(channelX, channelY int) (float64, float64) {
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	return io.motor(channelX).CurrentAngle, io.motor(channelY).CurrentAngle
 }
 func (io *IO) Reset() {
+	wait := time.Second
 	for k, _ := range io.motorAngle {
-		_, _ = io.SetServoAngle(k, 90)
+		d, _ := io.SetServoAngle(k, 90)
+		wait = max(wait, d)
 	}
-	time.Sleep(1 * time.Second)
+	time.Sleep(wait)
 }
 func (io *IO) Close() {
+	io.mu.Lock()
+	if io.idleStop != nil {
+		close(io.idleStop)
+		io.idleStop = nil
+	}
+	io.mu.Unlock()
 	io.Reset()
-	for _, l := range io.lines {
-		_ = l.SetValue(0)
-		_ = l.Reconfigure(gpiocdev.AsInput)
+	io.mu.Lock()
+	close(io.done)
+	io.mu.Unlock()
+	io.pinMu.Lock()
+	for offset, l := range io.lines {
+		level := io.inactive[offset]
+		_ = l.SetValue(level)
+		if level == 0 {
+			// An active low line stays driven high; released as an input
+			// it could float on.
+			_ = l.Reconfigure(gpiocdev.AsInput)
+		}
 		_ = l.Close()
 	}
+	io.pinMu.Unlock()
 	// Stop and halt the servos, setting them to a neutral position
-	if io.servos != nil {
-		_ = io.servos.Halt()
+	io.mu.Lock()
+	servos := io.servos
+	io.mu.Unlock()
+	if servos != nil {
+		_ = servos.Halt()
 		time.Sleep(100 * time.Millisecond) // Give it time to halt
 	}
 	_ = io.chip.Close()
@@ -0,0 +1,215 @@
+package io
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Laser drives the laser module at a brightness between 0 and 100 percent.
+type Laser interface {
+	SetBrightness(percent float64) error
+	Brightness() float64
+}
+
+func clampPercent(p float64) float64 {
+	return math.Max(0, math.Min(100, p))
+}
+
+// PinLaser switches a laser on a plain GPIO line; any brightness above zero
+// is fully on.
+type PinLaser struct {
+	mu        sync.Mutex
+	pins      PinDriver
+	line      int
+	activeLow bool
+	level     float64
+}
+
+func NewPinLaser(pins PinDriver, line int, activeLow bool) *PinLaser {
+	if l, ok := pins.(InactiveLeveler); ok && activeLow {
+		l.SetInactiveLevel(line, 1)
+	}
+	return &PinLaser{pins: pins, line: line, activeLow: activeLow}
+}
+
+func (l *PinLaser) SetBrightness(percent float64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = clampPercent(percent)
+	state := 0
+	if (l.level > 0) != l.activeLow {
+		state = 1
+	}
+	return l.pins.SetPinState(l.line, state)
+}
+
+func (l *PinLaser) Brightness() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// PWMLaser dims a laser from a PCA9685 channel.
+type PWMLaser struct {
+	mu      sync.Mutex
+	io      *IO
+	channel int
+	level   float64
+}
+
+// PWMLaser returns a laser driven by channel of the servo PCA9685.
+func (io *IO) PWMLaser(channel int) *PWMLaser {
+	return &PWMLaser{io: io, channel: channel}
+}
+
+func (l *PWMLaser) SetBrightness(percent float64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = clampPercent(percent)
+	on, off := dutyTicks(l.level)
+	return l.io.writeLaser(l.channel, on, off)
+}
+
+func (l *PWMLaser) Brightness() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// dutyTicks converts a percentage to PCA9685 on/off values, using the
+// dedicated full-on/full-off bits at the ends.
+func dutyTicks(percent float64) (uint16, uint16) {
+	switch {
+	case percent <= 0:
+		return 0, 4096
+	case percent >= 100:
+		return 4096, 0
+	}
+	return 0, uint16(math.Round(percent / 100 * 4095))
+}
+
+// Fade ramps l from its current brightness to target over d.
+func Fade(ctx context.Context, l Laser, target float64, d time.Duration) error {
+	const step = 20 * time.Millisecond
+	from := l.Brightness()
+	steps := int(d / step)
+	for i := 1; i <= steps; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(step):
+		}
+		t := float64(i) / float64(steps)
+		if err := l.SetBrightness(from + (target-from)*t); err != nil {
+			return err
+		}
+	}
+	return l.SetBrightness(target)
+}
+
+const (
+	EffectNone    = "none"
+	EffectStrobe  = "strobe"
+	EffectFlicker = "flicker"
+)
+
+// LaserEffect modulates the brightness of a laser over time.
+type LaserEffect struct {
+	Type  string  `json:"type"`  // "none", "strobe" or "flicker"
+	Hz    float64 `json:"hz"`    // strobe rate, or flicker update rate
+	Duty  float64 `json:"duty"`  // fraction of each strobe period the laser is on
+	Depth float64 `json:"depth"` // how far flicker may dim, 0-1
+}
+
+func (e LaserEffect) Validate() error {
+	switch e.Type {
+	case EffectNone, "":
+		return nil
+	case EffectStrobe, EffectFlicker:
+	default:
+		return fmt.Errorf("unknown laser effect: %s", e.Type)
+	}
+	if e.Hz <= 0 {
+		return fmt.Errorf("laser effect %s needs a positive rate", e.Type)
+	}
+	if e.Type == EffectStrobe && (e.Duty <= 0 || e.Duty >= 1) {
+		return fmt.Errorf("strobe duty must be between 0 and 1, got %v", e.Duty)
+	}
+	return nil
+}
+
+// EffectLaser applies a LaserEffect on top of the brightness it is given.
+type EffectLaser struct {
+	Laser
+	mu     sync.Mutex
+	effect LaserEffect
+	level  float64
+}
+
+func NewEffectLaser(l Laser, effect LaserEffect) *EffectLaser {
+	return &EffectLaser{Laser: l, effect: effect}
+}
+
+// SetBrightness holds e.mu across the write so Run cannot follow it with a
+// stale level.
+func (e *EffectLaser) SetBrightness(percent float64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.level = clampPercent(percent)
+	return e.Laser.SetBrightness(percent)
+}
+
+func (e *EffectLaser) Brightness() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.level
+}
+
+// Run modulates the laser until ctx is done.
+func (e *EffectLaser) Run(ctx context.Context) {
+	if e.effect.Type == EffectNone || e.effect.Type == "" || e.effect.Validate() != nil {
+		return
+	}
+	period := time.Duration(float64(time.Second) / e.effect.Hz)
+	interval := period
+	if e.effect.Type == EffectStrobe {
+		interval = time.Duration(float64(period) * e.effect.Duty)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	on := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.mu.Lock()
+		if e.level > 0 {
+			on = e.modulate(on, period, ticker)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// modulate writes one step of the effect. Callers must hold e.mu.
+func (e *EffectLaser) modulate(on bool, period time.Duration, ticker *time.Ticker) bool {
+	switch e.effect.Type {
+	case EffectStrobe:
+		on = !on
+		if on {
+			_ = e.Laser.SetBrightness(e.level)
+			ticker.Reset(time.Duration(float64(period) * e.effect.Duty))
+		} else {
+			_ = e.Laser.SetBrightness(0)
+			ticker.Reset(time.Duration(float64(period) * (1 - e.effect.Duty)))
+		}
+	case EffectFlicker:
+		_ = e.Laser.SetBrightness(e.level * (1 - e.effect.Depth*rand.Float64()))
+	}
+	return on
+}
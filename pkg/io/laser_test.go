@@ -0,0 +1,43 @@
+package io
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// slowLaser takes a while for every write, widening the window in which an
+// effect step can race a new brightness.
+type slowLaser struct {
+	SimLaser
+}
+
+func (l *slowLaser) SetBrightness(percent float64) error {
+	time.Sleep(200 * time.Microsecond)
+	return l.SimLaser.SetBrightness(percent)
+}
+
+func TestEffectLaserOffRacingRun(t *testing.T) {
+	for _, effect := range []LaserEffect{
+		{Type: EffectFlicker, Hz: 5000, Depth: 0.5},
+		{Type: EffectStrobe, Hz: 2000, Duty: 0.5},
+	} {
+		t.Run(effect.Type, func(t *testing.T) {
+			l := &slowLaser{}
+			e := NewEffectLaser(l, effect)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go e.Run(ctx)
+			for i := range 200 {
+				e.SetBrightness(80)
+				time.Sleep(time.Duration(i%7) * 100 * time.Microsecond)
+				e.SetBrightness(0)
+				// Give Run a few ticks to write a stale level.
+				time.Sleep(2 * time.Millisecond)
+				if got := l.Brightness(); got != 0 {
+					t.Fatalf("laser at %v after SetBrightness(0), want 0", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package io
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// MotionLimits bounds how fast a servo moves. The commanded pulse is ramped
+// within them, and they tell callers when the servo will arrive.
+type MotionLimits struct {
+	// Speed is the time needed to travel 60°, the way servo datasheets
+	// quote it (an SG90 is about 0.1s/60°).
+	Speed time.Duration `json:"speed"`
+	// Acceleration in °/s². 0 lets the servo reach full speed instantly.
+	Acceleration float64 `json:"acceleration"`
+}
+
+// DefaultMotionLimits match the pace the controller has always moved at,
+// roughly 100°/s.
+func DefaultMotionLimits() MotionLimits {
+	return MotionLimits{Speed: 600 * time.Millisecond}
+}
+
+func (m MotionLimits) Validate() error {
+	if m.Speed <= 0 {
+		return fmt.Errorf("servo speed must be positive, got %v", m.Speed)
+	}
+	if m.Acceleration < 0 {
+		return fmt.Errorf("servo acceleration must not be negative, got %v", m.Acceleration)
+	}
+	return nil
+}
+
+// velocity is the top speed in °/s.
+func (m MotionLimits) velocity() float64 {
+	return 60 / m.Speed.Seconds()
+}
+
+const (
+	// rampInterval is how often a moving servo's pulse is updated, about
+	// every other PWM period at the PCA9685's default frequency.
+	rampInterval  = 10 * time.Millisecond
+	modelStep     = time.Millisecond
+	modelHorizon  = 30 * time.Second
+	arriveEpsilon = 0.01
+)
+
+// servoModel estimates the real position of a servo that was commanded to
+// target, carrying its velocity across commands.
+type servoModel struct {
+	limits   MotionLimits
+	pos, vel float64
+	target   float64
+	at       time.Time
+	arrival  time.Time
+}
+
+func newServoModel(limits MotionLimits, angle float64) *servoModel {
+	return &servoModel{limits: limits, pos: angle, target: angle}
+}
+
+// advance integrates the model forward to now.
+func (m *servoModel) advance(now time.Time) {
+	if m.at.IsZero() {
+		m.at = now
+		return
+	}
+	for now.Sub(m.at) >= modelStep {
+		m.step(modelStep.Seconds())
+		m.at = m.at.Add(modelStep)
+		if m.pos == m.target && m.vel == 0 {
+			m.at = now
+			return
+		}
+	}
+}
+
+func (m *servoModel) step(dt float64) {
+	d := m.target - m.pos
+	vmax := m.limits.velocity()
+	want := math.Copysign(vmax, d)
+	if a := m.limits.Acceleration; a > 0 {
+		// Start braking early enough to stop on the target.
+		want = math.Copysign(math.Min(vmax, math.Sqrt(2*a*math.Abs(d))), d)
+		dv := want - m.vel
+		limit := a * dt
+		m.vel += math.Max(-limit, math.Min(limit, dv))
+	} else {
+		m.vel = want
+	}
+	next := m.pos + m.vel*dt
+	if math.Abs(d) < arriveEpsilon || (d > 0) != (m.target-next > 0) {
+		m.pos, m.vel = m.target, 0
+		return
+	}
+	m.pos = next
+}
+
+// command points the model at a new target and returns how long until the
+// servo gets there.
+func (m *servoModel) command(target float64, now time.Time) time.Duration {
+	m.advance(now)
+	m.target = target
+	sim := *m
+	var t time.Duration
+	for t < modelHorizon && (sim.pos != sim.target || sim.vel != 0) {
+		sim.step(modelStep.Seconds())
+		t += modelStep
+	}
+	m.arrival = now.Add(t)
+	return t
+}
+
+// position returns the estimated angle at now.
+func (m *servoModel) position(now time.Time) float64 {
+	m.advance(now)
+	return m.pos
+}
@@ -0,0 +1,290 @@
+package io
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gobot.io/x/gobot/drivers/i2c"
+	"gobot.io/x/gobot/platforms/raspi"
+	"periph.io/x/conn/v3/gpio"
+	periphi2c "periph.io/x/conn/v3/i2c"
+	"periph.io/x/conn/v3/i2c/i2creg"
+	"periph.io/x/devices/v3/pca9685"
+	"periph.io/x/host/v3"
+)
+
+const (
+	BackendGobot  = "gobot"
+	BackendPeriph = "periph"
+	// BackendSysfs uses the kernel's hardware PWM instead of a PCA9685.
+	BackendSysfs = "sysfs"
+
+	// nominalOscillatorHz is the PCA9685's internal oscillator as specified.
+	nominalOscillatorHz = 25_000_000
+	// powerOnPrescale is the prescale a PCA9685 wakes up with, about 197Hz.
+	// DefaultCalibration is tuned for it.
+	powerOnPrescale = 30
+
+	prescaleRegister = 0xfe
+
+	mode1Register = 0x00
+	mode1Sleep    = 0x10 // oscillator off, set again by a power-on reset
+	mode1Restart  = 0x80 // outputs were running when the oscillator slept
+	mode1AutoInc  = 0x20 // advance the register pointer after each byte
+)
+
+// PWMDriver is the 16-channel, 12-bit PWM chip the servos hang off.
+type PWMDriver interface {
+	SetPWM(channel int, on, off uint16) error
+	Halt() error
+}
+
+// PCA9685Config selects and configures the PCA9685 driver.
+type PCA9685Config struct {
+	Backend string `json:"backend"` // "gobot", "periph" or "sysfs"
+	Bus     int    `json:"bus"`     // I2C bus number
+	Address int    `json:"address"` // 0x40 unless the address jumpers are bridged
+	// Frequency is the PWM frequency in Hz. 0 runs every backend at the
+	// PCA9685's power-on rate of about 197Hz, which DefaultCalibration is
+	// tuned for. Calibrations are in ticks of this period, so they have to
+	// be redone after changing it.
+	Frequency float64 `json:"frequency"`
+	// OscillatorHz is the measured oscillator frequency of this board, used
+	// to correct the prescale. 0 means the nominal 25MHz.
+	OscillatorHz float64 `json:"oscillatorHz"`
+	// SysfsChip is the pwmchip number used by the sysfs backend, whose
+	// channels are the chip's PWM outputs. It runs at the same frequency a
+	// PCA9685 would, so the same calibrations apply.
+	SysfsChip int `json:"sysfsChip"`
+	// Retry is how long to keep looking for a board that is missing at
+	// boot, for example while the I2C bus is still coming up, or that
+	// stopped answering later on.
+	Retry Backoff `json:"retry"`
+	// HealthCheck is how often the board is checked for a reset that wiped
+	// its configuration. 0 only checks after a failed write.
+	HealthCheck time.Duration `json:"healthCheck"`
+}
+
+func DefaultPCA9685Config() PCA9685Config {
+	return PCA9685Config{
+		Backend:     BackendGobot,
+		Bus:         1,
+		Address:     0x40,
+		Retry:       DefaultBackoff(),
+		HealthCheck: 2 * time.Second,
+	}
+}
+
+func (c PCA9685Config) oscillator() float64 {
+	if c.OscillatorHz <= 0 {
+		return nominalOscillatorHz
+	}
+	return c.OscillatorHz
+}
+
+// prescale returns the prescale register value that makes the board output
+// Frequency, or the power-on value when it is 0.
+func (c PCA9685Config) prescale() byte {
+	if c.Frequency <= 0 {
+		return powerOnPrescale
+	}
+	p := math.Round(c.oscillator()/(4096*c.Frequency)) - 1
+	return byte(math.Max(3, math.Min(255, p)))
+}
+
+// frequency returns the PWM frequency the board really outputs, which
+// other backends match so that tick calibrations carry over.
+func (c PCA9685Config) frequency() float64 {
+	return c.oscillator() / (4096 * (float64(c.prescale()) + 1))
+}
+
+// NewPCA9685 opens the PCA9685 described by cfg. Failures to reach the
+// board are returned as a *HardwareError.
+func NewPCA9685(cfg PCA9685Config) (PWMDriver, error) {
+	switch cfg.Backend {
+	case BackendGobot, "":
+		return newGobotPCA9685(cfg)
+	case BackendPeriph:
+		return newPeriphPCA9685(cfg)
+	case BackendSysfs:
+		return NewSysfsPWM(SysfsPWMRoot, cfg.SysfsChip, cfg.frequency())
+	}
+	return nil, fmt.Errorf("unknown PCA9685 backend: %s", cfg.Backend)
+}
+
+// HealthChecker is implemented by PWM drivers that can tell whether the
+// chip still holds the configuration it was started with.
+type HealthChecker interface {
+	CheckHealth() error
+}
+
+var (
+	_ BatchPWMDriver = (*gobotPCA9685)(nil)
+	_ BatchPWMDriver = (*periphPCA9685)(nil)
+	_ HealthChecker  = (*gobotPCA9685)(nil)
+	_ HealthChecker  = (*periphPCA9685)(nil)
+)
+
+// checkMode1 reports a chip that went through a power-on reset: it wakes up
+// asleep and with auto-increment off, both of which start clears.
+func checkMode1(mode byte) error {
+	if mode&mode1Sleep != 0 || mode&mode1AutoInc == 0 {
+		return fmt.Errorf("MODE1 is 0x%02x: %w", mode, ErrChipReset)
+	}
+	return nil
+}
+
+// setPrescale changes the PWM frequency. The prescale can only be written
+// while the oscillator sleeps; once it is awake again the restart bit
+// resumes the outputs where they were.
+func setPrescale(prescale byte, read func(reg byte) (byte, error), write func(reg, value byte) error) error {
+	mode, err := read(mode1Register)
+	if err != nil {
+		return err
+	}
+	mode &^= mode1Restart | mode1Sleep
+	if err := write(mode1Register, mode|mode1Sleep); err != nil {
+		return err
+	}
+	if err := write(prescaleRegister, prescale); err != nil {
+		return err
+	}
+	if err := write(mode1Register, mode); err != nil {
+		return err
+	}
+	time.Sleep(5 * time.Millisecond)
+	return write(mode1Register, mode|mode1Restart)
+}
+
+// gobotPCA9685 adds batched writes to the gobot driver through a second
+// connection to the same device, since the driver keeps its own private.
+type gobotPCA9685 struct {
+	*i2c.PCA9685Driver
+	adaptor *raspi.Adaptor
+	conn    i2c.Connection
+}
+
+func newGobotPCA9685(cfg PCA9685Config) (PWMDriver, error) {
+	adaptor := raspi.NewAdaptor()
+	d := i2c.NewPCA9685Driver(adaptor,
+		i2c.WithBus(cfg.Bus),
+		i2c.WithAddress(cfg.Address),
+	)
+	if err := d.Start(); err != nil {
+		_ = adaptor.Finalize()
+		return nil, i2cError(SubsystemPWM, fmt.Errorf("failed to start PCA9685 on bus %d at 0x%02x: %w", cfg.Bus, cfg.Address, err))
+	}
+	conn, err := adaptor.GetConnection(cfg.Address, cfg.Bus)
+	if err != nil {
+		_ = adaptor.Finalize()
+		return nil, i2cError(SubsystemPWM, fmt.Errorf("failed to open PCA9685 connection: %w", err))
+	}
+	err = setPrescale(cfg.prescale(), conn.ReadByteData, func(reg, value byte) error {
+		return conn.WriteByteData(reg, value)
+	})
+	if err != nil {
+		_ = adaptor.Finalize()
+		return nil, i2cError(SubsystemPWM, fmt.Errorf("failed to set PCA9685 frequency: %w", err))
+	}
+	// gobot leaves auto-increment off, which batched writes rely on.
+	mode, err := conn.ReadByteData(mode1Register)
+	if err == nil {
+		err = conn.WriteByteData(mode1Register, mode|mode1AutoInc)
+	}
+	if err != nil {
+		_ = adaptor.Finalize()
+		return nil, i2cError(SubsystemPWM, fmt.Errorf("failed to enable PCA9685 auto-increment: %w", err))
+	}
+	return &gobotPCA9685{PCA9685Driver: d, adaptor: adaptor, conn: conn}, nil
+}
+
+func (g *gobotPCA9685) SetPWMs(writes []PWMWrite) error {
+	return writeBatch(g, func(data []byte) error {
+		_, err := g.conn.Write(data)
+		return err
+	}, writes)
+}
+
+func (g *gobotPCA9685) CheckHealth() error {
+	mode, err := g.conn.ReadByteData(mode1Register)
+	if err != nil {
+		return err
+	}
+	return checkMode1(mode)
+}
+
+// Close releases the bus without touching the outputs, for a board that
+// is about to be re-initialized.
+func (g *gobotPCA9685) Close() error {
+	return g.adaptor.Finalize()
+}
+
+type periphPCA9685 struct {
+	bus periphi2c.BusCloser
+	dev *pca9685.Dev
+	raw *periphi2c.Dev // same device, for batched writes
+}
+
+func newPeriphPCA9685(cfg PCA9685Config) (PWMDriver, error) {
+	if _, err := host.Init(); err != nil {
+		return nil, i2cError(SubsystemPWM, fmt.Errorf("failed to initialize periph host: %w", err))
+	}
+	bus, err := i2creg.Open(fmt.Sprintf("I2C%d", cfg.Bus))
+	if err != nil {
+		return nil, &HardwareError{Subsystem: SubsystemPWM, Kind: ErrI2CDisabled, Err: fmt.Errorf("failed to open I2C bus %d: %w", cfg.Bus, err)}
+	}
+	dev, err := pca9685.NewI2C(bus, uint16(cfg.Address))
+	if err != nil {
+		_ = bus.Close()
+		return nil, i2cError(SubsystemPWM, fmt.Errorf("failed to start PCA9685 on bus %d at 0x%02x: %w", cfg.Bus, cfg.Address, err))
+	}
+	raw := &periphi2c.Dev{Bus: bus, Addr: uint16(cfg.Address)}
+	// periph always starts at 50Hz and its own prescale math is one off, so
+	// the prescale is written the same way as for gobot.
+	err = setPrescale(cfg.prescale(), func(reg byte) (byte, error) {
+		var v [1]byte
+		err := raw.Tx([]byte{reg}, v[:])
+		return v[0], err
+	}, func(reg, value byte) error {
+		_, err := raw.Write([]byte{reg, value})
+		return err
+	})
+	if err != nil {
+		_ = bus.Close()
+		return nil, i2cError(SubsystemPWM, fmt.Errorf("failed to set PCA9685 frequency: %w", err))
+	}
+	return &periphPCA9685{bus: bus, dev: dev, raw: raw}, nil
+}
+
+func (p *periphPCA9685) SetPWM(channel int, on, off uint16) error {
+	return p.dev.SetPwm(channel, gpio.Duty(on), gpio.Duty(off))
+}
+
+// SetPWMs relies on the auto-increment mode periph enables at init.
+func (p *periphPCA9685) SetPWMs(writes []PWMWrite) error {
+	return writeBatch(p, func(data []byte) error {
+		_, err := p.raw.Write(data)
+		return err
+	}, writes)
+}
+
+func (p *periphPCA9685) CheckHealth() error {
+	var mode [1]byte
+	if err := p.raw.Tx([]byte{mode1Register}, mode[:]); err != nil {
+		return err
+	}
+	return checkMode1(mode[0])
+}
+
+func (p *periphPCA9685) Close() error {
+	return p.bus.Close()
+}
+
+func (p *periphPCA9685) Halt() error {
+	err := p.dev.SetAllPwm(0, 0)
+	if cerr := p.bus.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package io
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// FaultStats counts the failures of a piece of hardware and how often it
+// was brought back.
+type FaultStats struct {
+	Faults     uint64    `json:"faults"`     // failed writes and health checks
+	Resets     uint64    `json:"resets"`     // times the chip was found reset
+	Recoveries uint64    `json:"recoveries"` // successful re-initializations
+	LastError  string    `json:"lastError,omitempty"`
+	LastFault  time.Time `json:"lastFault,omitempty"`
+}
+
+// writePWM sets one channel, remembering it so it can be restored after a
+// reset. Callers must hold io.mu.
+func (io *IO) writePWM(channel int, on, off uint16) error {
+	return io.writePWMs([]PWMWrite{{Channel: channel, On: on, Off: off}})
+}
+
+// writePWMs is writePWM for several channels at once. Callers must hold
+// io.mu.
+func (io *IO) writePWMs(writes []PWMWrite) error {
+	io.outMu.Lock()
+	for _, w := range writes {
+		io.lastPWM[w.Channel] = w
+	}
+	io.outMu.Unlock()
+	servos, err := io.pwm()
+	if err != nil {
+		return err
+	}
+	if err := setPWMs(servos, writes); err != nil {
+		io.pwmFailed(err)
+		return &HardwareError{Subsystem: SubsystemPWM, Kind: ErrDeviceNotFound, Err: err}
+	}
+	return nil
+}
+
+// writeLaser sets a laser channel without waiting for io.mu, which a servo
+// move stuck on the bus may be holding, so the watchdog can always switch
+// the laser off. Depending on the backend a write may take several bus
+// transactions that interleave with servo writes; that is safe because
+// each touches only its own channel's registers.
+func (io *IO) writeLaser(channel int, on, off uint16) error {
+	io.outMu.Lock()
+	defer io.outMu.Unlock()
+	io.lastPWM[channel] = PWMWrite{Channel: channel, On: on, Off: off}
+	if io.servos == nil {
+		return &HardwareError{Subsystem: SubsystemPWM, Kind: ErrNotReady}
+	}
+	if err := io.servos.SetPWM(channel, on, off); err != nil {
+		go func() {
+			io.mu.Lock()
+			defer io.mu.Unlock()
+			io.pwmFailed(err)
+		}()
+		return &HardwareError{Subsystem: SubsystemPWM, Kind: ErrDeviceNotFound, Err: err}
+	}
+	return nil
+}
+
+// setServos swaps the PWM driver. Callers must hold io.mu.
+func (io *IO) setServos(servos PWMDriver) {
+	io.outMu.Lock()
+	defer io.outMu.Unlock()
+	io.servos = servos
+}
+
+// pwmFailed records a failed write or health check. A board that still
+// holds its configuration only had a glitch on the bus; otherwise it is
+// dropped and re-initialized in the background. Callers must hold io.mu.
+func (io *IO) pwmFailed(err error) {
+	io.pwmStats.Faults++
+	io.pwmStats.LastError = err.Error()
+	io.pwmStats.LastFault = time.Now()
+	if io.servos == nil {
+		return
+	}
+	if checker, ok := io.servos.(HealthChecker); ok && !errors.Is(err, ErrChipReset) {
+		health := checker.CheckHealth()
+		if health == nil {
+			log.Printf("PCA9685 write failed: %s", err)
+			return
+		}
+		if errors.Is(health, ErrChipReset) {
+			err = health
+		}
+	}
+	if errors.Is(err, ErrChipReset) {
+		io.pwmStats.Resets++
+	}
+	log.Printf("PCA9685 lost, re-initializing: %s", err)
+	if c, ok := io.servos.(interface{ Close() error }); ok {
+		_ = c.Close()
+	}
+	io.setServos(nil)
+	io.faults[SubsystemPWM] = err
+	if !io.connecting {
+		io.connecting = true
+		go io.connectPWM()
+	}
+}
+
+// watchPWM checks the board for a reset every interval until Close.
+func (io *IO) watchPWM(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-io.done:
+			return
+		case <-ticker.C:
+		}
+		io.mu.Lock()
+		if checker, ok := io.servos.(HealthChecker); ok {
+			if err := checker.CheckHealth(); err != nil {
+				io.pwmFailed(err)
+			}
+		}
+		io.mu.Unlock()
+	}
+}
+
+// connectPWM keeps trying to open the PCA9685 until it answers, Close is
+// called or the configured attempts run out. The last commanded output of
+// every channel is restored before the board is used again.
+func (io *IO) connectPWM() {
+	err := retryAfter(io.done, io.pwmConfig.Retry, func() error {
+		servos, err := NewPCA9685(io.pwmConfig)
+		io.mu.Lock()
+		defer io.mu.Unlock()
+		if err == nil {
+			err = io.restore(servos)
+		}
+		if err != nil {
+			io.faults[SubsystemPWM] = err
+			io.pwmStats.LastError = err.Error()
+			return err
+		}
+		io.connecting = false
+		delete(io.faults, SubsystemPWM)
+		io.pwmStats.Recoveries++
+		return nil
+	})
+	if err != nil {
+		log.Printf("giving up on PCA9685: %s", err)
+		io.mu.Lock()
+		io.connecting = false
+		io.mu.Unlock()
+		return
+	}
+	log.Printf("PCA9685 connected")
+}
+
+// restore replays the last commanded outputs on a freshly started board and
+// starts using it, or closes it if that fails. Laser writes wait until it
+// is done so none of them is lost in between. Callers must hold io.mu.
+func (io *IO) restore(servos PWMDriver) error {
+	io.outMu.Lock()
+	defer io.outMu.Unlock()
+	for _, w := range io.lastPWM {
+		if err := servos.SetPWM(w.Channel, w.On, w.Off); err != nil {
+			if c, ok := servos.(interface{ Close() error }); ok {
+				_ = c.Close()
+			}
+			return err
+		}
+	}
+	io.servos = servos
+	return nil
+}
+
+// FaultStats returns the failure counts of the PCA9685.
+func (io *IO) FaultStats() map[string]FaultStats {
+	io.mu.Lock()
+	defer io.mu.Unlock()
+	return map[string]FaultStats{SubsystemPWM: io.pwmStats}
+}
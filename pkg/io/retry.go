@@ -0,0 +1,45 @@
+package io
+
+import "time"
+
+// Backoff controls how a device missing at boot is retried.
+type Backoff struct {
+	Initial  time.Duration `json:"initial"`  // wait before the first retry
+	Max      time.Duration `json:"max"`      // cap for the doubling wait
+	Attempts int           `json:"attempts"` // retries before giving up, 0 retries forever
+}
+
+func DefaultBackoff() Backoff {
+	return Backoff{
+		Initial: 500 * time.Millisecond,
+		Max:     30 * time.Second,
+	}
+}
+
+// retryAfter retries fn after a failed first attempt, doubling the wait
+// each time up to b.Max. It returns the last error once b.Attempts are used
+// up or stop is closed.
+func retryAfter(stop <-chan struct{}, b Backoff, fn func() error) error {
+	def := DefaultBackoff()
+	wait := b.Initial
+	if wait <= 0 {
+		wait = def.Initial
+	}
+	limit := b.Max
+	if limit <= 0 {
+		limit = def.Max
+	}
+	var err error
+	for attempt := 1; b.Attempts <= 0 || attempt <= b.Attempts; attempt++ {
+		select {
+		case <-stop:
+			return err
+		case <-time.After(wait):
+		}
+		if err = fn(); err == nil {
+			return nil
+		}
+		wait = min(wait*2, limit)
+	}
+	return err
+}
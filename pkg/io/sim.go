@@ -0,0 +1,301 @@
+package io
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultSimServoSpeed is the time a simulated servo needs to travel 60°,
+// matching a typical SG90.
+const DefaultSimServoSpeed = 100 * time.Millisecond
+
+// Sim is a fully simulated hardware backend: two virtual servos, virtual
+// output pins, a left/right button pair and a motion sensor.
+type Sim struct {
+	Servos *SimServos
+	Pins   *SimPins
+	Laser  *SimLaser
+	Left   *SimButton
+	Right  *SimButton
+	Motion *SimSensor // PIR sensor, active while it sees movement
+}
+
+func NewSim(buttons ButtonConfig) *Sim {
+	return &Sim{
+		Servos: NewSimServos(DefaultSimServoSpeed),
+		Pins:   NewSimPins(),
+		Laser:  &SimLaser{},
+		Left:   NewSimButton(buttons),
+		Right:  NewSimButton(buttons),
+		Motion: NewSimSensor(MotionSensorDebounce),
+	}
+}
+
+// SimInput is a simulated input that can be held active for a while.
+type SimInput interface {
+	Press(d time.Duration)
+}
+
+// Input looks up a simulated input by name ("left"/"l", "right"/"r" or
+// "motion"/"m").
+func (s *Sim) Input(name string) (SimInput, error) {
+	switch name {
+	case "left", "l":
+		return s.Left, nil
+	case "right", "r":
+		return s.Right, nil
+	case "motion", "m":
+		return s.Motion, nil
+	}
+	return nil, fmt.Errorf("unknown input: %s", name)
+}
+
+// SimServos tracks virtual servos that move towards their commanded angle
+// following the same motion model IO uses for its estimates.
+type SimServos struct {
+	mu          sync.Mutex
+	speed       time.Duration
+	servos      map[int]*servoModel
+	calibration map[int]Calibration
+	limits      map[int]MotionLimits
+}
+
+// NewSimServos creates virtual servos that need speed to travel 60°.
+func NewSimServos(speed time.Duration) *SimServos {
+	if speed <= 0 {
+		speed = DefaultSimServoSpeed
+	}
+	return &SimServos{
+		speed:       speed,
+		servos:      make(map[int]*servoModel),
+		calibration: make(map[int]Calibration),
+		limits:      make(map[int]MotionLimits),
+	}
+}
+
+func (s *SimServos) servo(channel int) *servoModel {
+	if _, ok := s.servos[channel]; !ok {
+		limits, ok := s.limits[channel]
+		if !ok {
+			limits = MotionLimits{Speed: s.speed}
+		}
+		s.servos[channel] = newServoModel(limits, 90)
+	}
+	return s.servos[channel]
+}
+
+// Angle returns where the servo on channel is right now, which may still be
+// between its previous and commanded angle.
+func (s *SimServos) Angle(channel int) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servo(channel).position(time.Now())
+}
+
+func (s *SimServos) Position(channel int) (float64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.servo(channel)
+	return m.position(time.Now()), m.arrival
+}
+
+func (s *SimServos) SetMotionLimits(channel int, limits MotionLimits) error {
+	if err := limits.Validate(); err != nil {
+		return fmt.Errorf("channel %d: %w", channel, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits[channel] = limits
+	if m, ok := s.servos[channel]; ok {
+		m.limits = limits
+	}
+	return nil
+}
+
+// SetServoAngle commands a virtual servo and returns how long it needs to
+// get there.
+func (s *SimServos) SetServoAngle(channel int, angle float64) (time.Duration, error) {
+	if channel < 0 {
+		return 0, nil
+	}
+	angle = math.Max(0, math.Min(180, angle))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servo(channel).command(angle, time.Now()), nil
+}
+
+// SetXY commands both virtual servos at the same instant, like a batched
+// write to a real PCA9685.
+func (s *SimServos) SetXY(channelX, channelY int, x, y float64) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var arrive time.Duration
+	if channelX >= 0 {
+		arrive = s.servo(channelX).command(math.Max(0, math.Min(180, x)), now)
+	}
+	if channelY >= 0 {
+		arrive = max(arrive, s.servo(channelY).command(math.Max(0, math.Min(180, y)), now))
+	}
+	return arrive, nil
+}
+
+// GetXY returns the last commanded angles, like IO.GetXY.
+func (s *SimServos) GetXY(channelX, channelY int) (float64, float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.servo(channelX).target, s.servo(channelY).target
+}
+
+func (s *SimServos) Reset() {
+	s.mu.Lock()
+	channels := make([]int, 0, len(s.servos))
+	for k := range s.servos {
+		channels = append(channels, k)
+	}
+	s.mu.Unlock()
+	for _, k := range channels {
+		_, _ = s.SetServoAngle(k, 90)
+	}
+}
+
+func (s *SimServos) SetCalibration(channel int, c Calibration) error {
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("channel %d: %w", channel, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calibration[channel] = c
+	return nil
+}
+
+// Pulse returns the PWM ticks a real servo on channel would currently be
+// sent.
+func (s *SimServos) Pulse(channel int) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.calibration[channel]
+	if !ok {
+		c = DefaultCalibration()
+	}
+	return c.Pulse(s.servo(channel).target)
+}
+
+// SimPins records the state of virtual output lines.
+type SimPins struct {
+	mu     sync.Mutex
+	states map[int]int
+}
+
+func NewSimPins() *SimPins {
+	return &SimPins{states: make(map[int]int)}
+}
+
+func (p *SimPins) SetPinState(pinName int, state int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.states[pinName] != state {
+		fmt.Printf("sim: pin %d -> %d\n", pinName, state)
+	}
+	p.states[pinName] = state
+	return nil
+}
+
+// SetInactiveLevel starts a line that has not been written yet at level.
+func (p *SimPins) SetInactiveLevel(pinName int, level int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.states[pinName]; !ok {
+		p.states[pinName] = level
+	}
+}
+
+// State returns the last value written to a virtual line.
+func (p *SimPins) State(pinName int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.states[pinName]
+}
+
+// SimLaser records the brightness of a virtual dimmable laser.
+type SimLaser struct {
+	mu    sync.Mutex
+	level float64
+}
+
+func (l *SimLaser) SetBrightness(percent float64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = clampPercent(percent)
+	return nil
+}
+
+func (l *SimLaser) Brightness() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// NewSimGalvo starts a Galvo whose DAC writes go nowhere, for trying
+// streamed paths without mirrors.
+func NewSimGalvo(config GalvoConfig, motorX, motorY int, next ServoDriver) (*Galvo, error) {
+	return NewGalvo(config, discardBus{}, motorX, motorY, next)
+}
+
+type discardBus struct{}
+
+func (discardBus) Tx(addr uint16, w, r []byte) error {
+	return nil
+}
+
+// SimButton is a virtual button. Presses go through the same edge handling
+// as a real GPIO button.
+type SimButton struct {
+	*Button
+	mu sync.Mutex
+}
+
+func NewSimButton(config ButtonConfig) *SimButton {
+	return &SimButton{
+		Button: newButton(config),
+	}
+}
+
+// Press holds the button down for d and releases it. It returns immediately;
+// the edges are delivered in the background.
+func (b *SimButton) Press(d time.Duration) {
+	go func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.handleEdge(false)
+		time.Sleep(d)
+		b.handleEdge(true)
+	}()
+}
+
+// SimSensor is a virtual motion sensor. Its output goes through the same
+// edge handling as a real one.
+type SimSensor struct {
+	*EdgeWatcher
+	mu sync.Mutex
+}
+
+func NewSimSensor(debounce time.Duration) *SimSensor {
+	return &SimSensor{
+		EdgeWatcher: newEdgeWatcher(debounce),
+	}
+}
+
+// Press reports motion for d. It returns immediately; the edges are
+// delivered in the background.
+func (s *SimSensor) Press(d time.Duration) {
+	go func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.handleEdge(false)
+		time.Sleep(d)
+		s.handleEdge(true)
+	}()
+}
@@ -0,0 +1,418 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/warthog618/go-gpiocdev"
+)
+
+// OutputLine is a GPIO output, such as a *gpiocdev.Line.
+type OutputLine interface {
+	SetValue(value int) error
+}
+
+// InputLine is a GPIO input, such as a *gpiocdev.Line.
+type InputLine interface {
+	Value() (int, error)
+}
+
+// StepperConfig describes one axis driven by a step/dir driver such as an
+// A4988 or TMC2209.
+type StepperConfig struct {
+	StepLine   int `json:"stepLine"`
+	DirLine    int `json:"dirLine"`
+	EnableLine int `json:"enableLine"` // active low enable, -1 when hard wired
+	// EndstopLine is a switch the axis is homed against on startup, -1 to
+	// assume the axis starts at HomeAngle.
+	EndstopLine      int  `json:"endstopLine"`
+	EndstopActiveLow bool `json:"endstopActiveLow"`
+	// StepsPerDegree is full motor steps per degree of axis travel,
+	// including any gearing: 200/360 for a direct-drive 1.8° motor.
+	StepsPerDegree float64 `json:"stepsPerDegree"`
+	Microsteps     int     `json:"microsteps"` // as set on the driver's MS pins
+	InvertDir      bool    `json:"invertDir"`
+	// Limits bound speed and acceleration like they do for servos. Steppers
+	// lose steps without an acceleration ramp.
+	Limits        MotionLimits  `json:"limits"`
+	HomeAngle     float64       `json:"homeAngle"`     // axis angle at the endstop
+	HomeDirection int           `json:"homeDirection"` // -1 or 1, the way to the endstop
+	HomeSpeed     float64       `json:"homeSpeed"`     // °/s while looking for the endstop
+	PulseWidth    time.Duration `json:"pulseWidth"`    // minimum high time of a step pulse
+}
+
+func DefaultStepperConfig() StepperConfig {
+	return StepperConfig{
+		EnableLine:     -1,
+		EndstopLine:    -1,
+		StepsPerDegree: 200.0 / 360,
+		Microsteps:     16,
+		Limits:         MotionLimits{Speed: 300 * time.Millisecond, Acceleration: 720},
+		HomeDirection:  -1,
+		HomeSpeed:      20,
+		PulseWidth:     2 * time.Microsecond,
+	}
+}
+
+// UnmarshalJSON fills the fields a config leaves out from
+// DefaultStepperConfig, so an axis only needs its lines and gearing.
+func (c *StepperConfig) UnmarshalJSON(b []byte) error {
+	type plain StepperConfig
+	p := plain(DefaultStepperConfig())
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+	*c = StepperConfig(p)
+	return nil
+}
+
+func (c StepperConfig) Validate() error {
+	if c.StepLine < 0 || c.DirLine < 0 {
+		return fmt.Errorf("stepper needs step and dir lines, got %d and %d", c.StepLine, c.DirLine)
+	}
+	if c.StepsPerDegree <= 0 || c.Microsteps < 1 {
+		return fmt.Errorf("stepper needs positive steps per degree and microsteps, got %v and %d", c.StepsPerDegree, c.Microsteps)
+	}
+	if c.HomeDirection != -1 && c.HomeDirection != 1 {
+		return fmt.Errorf("stepper home direction must be -1 or 1, got %d", c.HomeDirection)
+	}
+	if c.EndstopLine >= 0 && c.HomeSpeed <= 0 {
+		return fmt.Errorf("stepper home speed must be positive, got %v", c.HomeSpeed)
+	}
+	return c.Limits.Validate()
+}
+
+// microstepsPerDegree is the resolution of the axis.
+func (c StepperConfig) microstepsPerDegree() float64 {
+	return c.StepsPerDegree * float64(c.Microsteps)
+}
+
+// homeTravel is how far homing looks for the endstop before giving up.
+const homeTravel = 360
+
+// Stepper drives one axis through a step/dir driver, ramping the step rate
+// up and down so the motor does not stall.
+type Stepper struct {
+	config            StepperConfig
+	step, dir, enable OutputLine
+	endstop           InputLine
+
+	mu       sync.Mutex
+	position int64   // microsteps from 0°
+	target   int64   // microsteps from 0°
+	rate     float64 // current microsteps per second
+	moving   int64   // direction of the current move, 0 when stopped
+	started  bool
+	wake     chan struct{}
+	stop     chan struct{}
+}
+
+// NewStepper creates an axis on the given lines. enable and endstop may be
+// nil. The axis does not move until Start is called.
+func NewStepper(config StepperConfig, step, dir, enable OutputLine, endstop InputLine) (*Stepper, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	s := &Stepper{
+		config:  config,
+		step:    step,
+		dir:     dir,
+		enable:  enable,
+		endstop: endstop,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	s.position = s.toSteps(config.HomeAngle)
+	s.target = s.position
+	if enable != nil {
+		if err := enable.SetValue(0); err != nil {
+			return nil, fmt.Errorf("failed enabling stepper driver: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Stepper requests the lines described by config from the chip and creates
+// an axis on them.
+func (io *IO) Stepper(config StepperConfig) (*Stepper, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	step, err := io.requestOutput(config.StepLine)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := io.requestOutput(config.DirLine)
+	if err != nil {
+		return nil, err
+	}
+	var enable OutputLine
+	if config.EnableLine >= 0 {
+		io.SetInactiveLevel(config.EnableLine, 1)
+		if enable, err = io.requestOutput(config.EnableLine); err != nil {
+			return nil, err
+		}
+	}
+	var endstop InputLine
+	if config.EndstopLine >= 0 {
+		bias := gpiocdev.WithPullDown
+		if config.EndstopActiveLow {
+			bias = gpiocdev.WithPullUp
+		}
+		line, err := io.chip.RequestLine(config.EndstopLine, gpiocdev.AsInput, bias)
+		if err != nil {
+			return nil, gpioError(fmt.Errorf("requesting endstop line %d: %w", config.EndstopLine, err), ErrLineUnavailable)
+		}
+		io.pinMu.Lock()
+		io.lines[config.EndstopLine] = line
+		io.pinMu.Unlock()
+		endstop = line
+	}
+	return NewStepper(config, step, dir, enable, endstop)
+}
+
+func (s *Stepper) toSteps(angle float64) int64 {
+	return int64(math.Round(angle * s.config.microstepsPerDegree()))
+}
+
+func (s *Stepper) toAngle(steps int64) float64 {
+	return float64(steps) / s.config.microstepsPerDegree()
+}
+
+// Home moves towards the endstop until it triggers and takes that spot as
+// HomeAngle. Without an endstop the axis is assumed to already be there.
+// It must be called before Start.
+func (s *Stepper) Home(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return fmt.Errorf("stepper must be homed before it is started")
+	}
+	home := s.toSteps(s.config.HomeAngle)
+	if s.endstop == nil {
+		s.position, s.target = home, home
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / (s.config.HomeSpeed * s.config.microstepsPerDegree()))
+	maxSteps := s.toSteps(homeTravel)
+	for i := int64(0); i <= maxSteps; i++ {
+		hit, err := s.atEndstop()
+		if err != nil {
+			return err
+		}
+		if hit {
+			s.position, s.target = home, home
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if err := s.pulse(int64(s.config.HomeDirection)); err != nil {
+			return err
+		}
+		time.Sleep(interval)
+	}
+	return fmt.Errorf("stepper endstop not reached within %d°", homeTravel)
+}
+
+func (s *Stepper) atEndstop() (bool, error) {
+	v, err := s.endstop.Value()
+	if err != nil {
+		return false, fmt.Errorf("failed reading endstop: %w", err)
+	}
+	return (v == 1) != s.config.EndstopActiveLow, nil
+}
+
+// pulse emits a single step in direction dir (-1 or 1).
+func (s *Stepper) pulse(dir int64) error {
+	level := 0
+	if (dir > 0) != s.config.InvertDir {
+		level = 1
+	}
+	if err := s.dir.SetValue(level); err != nil {
+		return err
+	}
+	if err := s.step.SetValue(1); err != nil {
+		return err
+	}
+	time.Sleep(s.config.PulseWidth)
+	return s.step.SetValue(0)
+}
+
+// Start runs the step generator until Close.
+func (s *Stepper) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started {
+		return
+	}
+	s.started = true
+	go s.run()
+}
+
+// SetAngle sets a new target and returns how long the axis needs to get
+// there from standstill.
+func (s *Stepper) SetAngle(angle float64) time.Duration {
+	s.mu.Lock()
+	s.target = s.toSteps(angle)
+	d := s.travelTime(math.Abs(float64(s.target - s.position)))
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return d
+}
+
+// Angle returns where the axis is now.
+func (s *Stepper) Angle() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.toAngle(s.position)
+}
+
+// Target returns the angle the axis is heading to.
+func (s *Stepper) Target() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.toAngle(s.target)
+}
+
+// SetLimits changes the speed and acceleration of later steps.
+func (s *Stepper) SetLimits(limits MotionLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.Limits = limits
+	return nil
+}
+
+// travelTime is the duration of a trapezoidal move over steps microsteps
+// starting and ending at rest. Callers must hold s.mu.
+func (s *Stepper) travelTime(steps float64) time.Duration {
+	perDegree := s.config.microstepsPerDegree()
+	vmax := s.config.Limits.velocity() * perDegree
+	accel := s.config.Limits.Acceleration * perDegree
+	var seconds float64
+	switch {
+	case accel <= 0:
+		seconds = steps / vmax
+	case steps >= vmax*vmax/accel:
+		seconds = steps/vmax + vmax/accel
+	default:
+		seconds = 2 * math.Sqrt(steps/accel)
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// next takes the next step towards the target and returns its direction
+// and the delay until the one after, or 0 when the axis is at rest on its
+// target. It follows the usual constant acceleration ramp: speed up while
+// there is room left to stop, slow down otherwise, and brake to a stop
+// before reversing.
+func (s *Stepper) next() (int64, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	perDegree := s.config.microstepsPerDegree()
+	vmax := s.config.Limits.velocity() * perDegree
+	accel := s.config.Limits.Acceleration * perDegree
+
+	remaining := s.target - s.position
+	want := int64(0)
+	if remaining > 0 {
+		want = 1
+	} else if remaining < 0 {
+		want = -1
+	}
+	if want == 0 {
+		s.moving, s.rate = 0, 0
+		return 0, 0
+	}
+	if accel <= 0 {
+		s.moving, s.rate = want, vmax
+		s.position += want
+		return want, time.Duration(float64(time.Second) / vmax)
+	}
+	if s.moving != 0 && s.moving != want {
+		// Keep braking until slower than a step off standstill.
+		if v2 := s.rate*s.rate - 2*accel; v2 > 2*accel {
+			s.rate = math.Sqrt(v2)
+			s.position += s.moving
+			return s.moving, time.Duration(float64(time.Second) / s.rate)
+		}
+		s.moving, s.rate = 0, 0
+	}
+	s.moving = want
+	if float64(remaining*want) <= s.rate*s.rate/(2*accel) {
+		s.rate = math.Sqrt(math.Max(s.rate*s.rate-2*accel, 0))
+	} else {
+		s.rate = math.Min(vmax, math.Sqrt(s.rate*s.rate+2*accel))
+	}
+	// Never slower than the first step off standstill.
+	s.rate = math.Max(s.rate, math.Min(vmax, math.Sqrt(2*accel)))
+	s.position += want
+	return want, time.Duration(float64(time.Second) / s.rate)
+}
+
+// run generates steps against a running schedule rather than sleeping
+// after each one, so coarse timers make it step in short bursts instead of
+// running slow.
+func (s *Stepper) run() {
+	var due time.Time
+	for {
+		dir, delay := s.next()
+		if dir == 0 && delay == 0 {
+			select {
+			case <-s.stop:
+				return
+			case <-s.wake:
+			case <-time.After(10 * time.Millisecond):
+			}
+			due = time.Time{}
+			continue
+		}
+		if due.IsZero() {
+			due = time.Now()
+		}
+		if err := s.pulse(dir); err != nil {
+			s.mu.Lock()
+			s.position -= dir
+			s.mu.Unlock()
+		}
+		due = due.Add(delay)
+		if wait := time.Until(due); wait > time.Millisecond {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(wait):
+			}
+		} else {
+			select {
+			case <-s.stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Close stops the step generator and disables the driver.
+func (s *Stepper) Close() {
+	s.mu.Lock()
+	if s.started {
+		close(s.stop)
+		s.started = false
+	}
+	s.mu.Unlock()
+	if s.enable != nil {
+		_ = s.enable.SetValue(1)
+	}
+}
@@ -0,0 +1,162 @@
+package io
+
+import (
+	"fmt"
+	"time"
+)
+
+// StepperDriver is a ServoDriver for axes on step/dir drivers. Channel
+// numbers name the axes the way PCA9685 channels name servos; channels
+// without a stepper are passed on to next, usually the PCA9685.
+type StepperDriver struct {
+	axes   map[int]*Stepper
+	failed map[int]error // axes that did not come up
+	next   ServoDriver
+}
+
+// NewStepperDriver starts every axis. Home them first. Channels in failed
+// have a stepper that could not be set up; moving them returns an error
+// instead of reaching next. next may be nil.
+func NewStepperDriver(axes map[int]*Stepper, failed map[int]error, next ServoDriver) *StepperDriver {
+	for _, s := range axes {
+		s.Start()
+	}
+	return &StepperDriver{axes: axes, failed: failed, next: next}
+}
+
+// stepper reports whether channel is a stepper axis, working or not.
+func (d *StepperDriver) stepper(channel int) bool {
+	_, ok := d.axes[channel]
+	_, failed := d.failed[channel]
+	return ok || failed
+}
+
+func (d *StepperDriver) SetServoAngle(channel int, angle float64) (time.Duration, error) {
+	if channel < 0 {
+		return 0, nil
+	}
+	if s, ok := d.axes[channel]; ok {
+		return s.SetAngle(angle), nil
+	}
+	if err, ok := d.failed[channel]; ok {
+		return 0, &HardwareError{Subsystem: fmt.Sprintf("%s%d", SubsystemStepper, channel), Kind: ErrNotReady, Err: err}
+	}
+	if d.next == nil {
+		return 0, fmt.Errorf("no stepper or servo on channel %d", channel)
+	}
+	return d.next.SetServoAngle(channel, angle)
+}
+
+func (d *StepperDriver) SetXY(channelX, channelY int, x, y float64) (time.Duration, error) {
+	if !d.stepper(channelX) && !d.stepper(channelY) && d.next != nil {
+		return d.next.SetXY(channelX, channelY, x, y)
+	}
+	dx, err := d.SetServoAngle(channelX, x)
+	if err != nil {
+		return 0, err
+	}
+	dy, err := d.SetServoAngle(channelY, y)
+	if err != nil {
+		return 0, err
+	}
+	return max(dx, dy), nil
+}
+
+// GetXY returns the target angles, like IO.GetXY.
+func (d *StepperDriver) GetXY(channelX, channelY int) (float64, float64) {
+	return d.target(channelX), d.target(channelY)
+}
+
+func (d *StepperDriver) target(channel int) float64 {
+	if s, ok := d.axes[channel]; ok {
+		return s.Target()
+	}
+	if d.next != nil {
+		x, _ := d.next.GetXY(channel, channel)
+		return x
+	}
+	return 0
+}
+
+// Position returns the angle a stepper has counted itself to, and when it
+// will reach its target.
+func (d *StepperDriver) Position(channel int) (float64, time.Time) {
+	if s, ok := d.axes[channel]; ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		remaining := float64(s.target - s.position)
+		if remaining < 0 {
+			remaining = -remaining
+		}
+		return s.toAngle(s.position), time.Now().Add(s.travelTime(remaining))
+	}
+	if d.next != nil {
+		return d.next.Position(channel)
+	}
+	return 0, time.Now()
+}
+
+func (d *StepperDriver) Reset() {
+	for _, s := range d.axes {
+		s.SetAngle(90)
+	}
+	if d.next != nil {
+		d.next.Reset()
+	} else {
+		time.Sleep(1 * time.Second)
+	}
+}
+
+// SetCalibration only applies to servo channels; steppers are calibrated
+// by their StepperConfig.
+func (d *StepperDriver) SetCalibration(channel int, c Calibration) error {
+	if d.stepper(channel) || d.next == nil {
+		return c.Validate()
+	}
+	return d.next.SetCalibration(channel, c)
+}
+
+func (d *StepperDriver) SetMotionLimits(channel int, limits MotionLimits) error {
+	if s, ok := d.axes[channel]; ok {
+		return s.SetLimits(limits)
+	}
+	if d.stepper(channel) || d.next == nil {
+		return limits.Validate()
+	}
+	return d.next.SetMotionLimits(channel, limits)
+}
+
+// Faults reports the axes that did not come up along with the faults of the
+// servo driver behind the steppers.
+func (d *StepperDriver) Faults() map[string]error {
+	faults := map[string]error{}
+	if r, ok := d.next.(FaultReporter); ok {
+		faults = r.Faults()
+	}
+	for ch, err := range d.failed {
+		faults[fmt.Sprintf("%s%d", SubsystemStepper, ch)] = err
+	}
+	return faults
+}
+
+// FaultStats passes on the failure counts of the servo driver behind the
+// steppers.
+func (d *StepperDriver) FaultStats() map[string]FaultStats {
+	if c, ok := d.next.(FaultCounter); ok {
+		return c.FaultStats()
+	}
+	return map[string]FaultStats{}
+}
+
+// Close stops and disables every axis.
+func (d *StepperDriver) Close() {
+	for _, s := range d.axes {
+		s.Close()
+	}
+}
+
+var (
+	_ ServoDriver   = (*StepperDriver)(nil)
+	_ FaultReporter = (*StepperDriver)(nil)
+	_ FaultCounter  = (*StepperDriver)(nil)
+)
@@ -0,0 +1,220 @@
+package io
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLine is an in-memory GPIO line. It counts rising edges and can be
+// read like an input.
+type fakeLine struct {
+	mu    sync.Mutex
+	value int
+	rises int
+	// onRise is called on every rising edge, e.g. to close a fake endstop
+	// after a number of steps.
+	onRise func()
+}
+
+func (f *fakeLine) SetValue(value int) error {
+	f.mu.Lock()
+	rose := f.value == 0 && value != 0
+	f.value = value
+	if rose {
+		f.rises++
+	}
+	onRise := f.onRise
+	f.mu.Unlock()
+	if rose && onRise != nil {
+		onRise()
+	}
+	return nil
+}
+
+func (f *fakeLine) Value() (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.value, nil
+}
+
+func (f *fakeLine) Rises() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rises
+}
+
+func testStepperConfig() StepperConfig {
+	c := DefaultStepperConfig()
+	c.StepsPerDegree = 1
+	c.Microsteps = 4
+	c.Limits = MotionLimits{Speed: 100 * time.Millisecond, Acceleration: 2000}
+	c.PulseWidth = 0
+	return c
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStepperSteps(t *testing.T) {
+	step, dir, enable := &fakeLine{}, &fakeLine{}, &fakeLine{}
+	s, err := NewStepper(testStepperConfig(), step, dir, enable, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := enable.Value(); v != 0 {
+		t.Errorf("enable = %d after NewStepper, want 0 (enabled)", v)
+	}
+	s.Start()
+	s.SetAngle(10)
+	waitFor(t, "move to 10°", func() bool { return s.Angle() == 10 })
+	if got := step.Rises(); got != 40 {
+		t.Errorf("stepped %d times to 10°, want 40", got)
+	}
+	if v, _ := dir.Value(); v != 1 {
+		t.Errorf("dir = %d moving forward, want 1", v)
+	}
+
+	s.SetAngle(5)
+	waitFor(t, "move back to 5°", func() bool { return s.Angle() == 5 })
+	if got := step.Rises(); got != 60 {
+		t.Errorf("stepped %d times after moving back 5°, want 60", got)
+	}
+	if v, _ := dir.Value(); v != 0 {
+		t.Errorf("dir = %d moving back, want 0", v)
+	}
+
+	s.Close()
+	if v, _ := enable.Value(); v != 1 {
+		t.Errorf("enable = %d after Close, want 1 (disabled)", v)
+	}
+}
+
+func TestStepperInvertDir(t *testing.T) {
+	c := testStepperConfig()
+	c.InvertDir = true
+	step, dir := &fakeLine{}, &fakeLine{}
+	s, err := NewStepper(c, step, dir, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Close()
+	s.SetAngle(1)
+	waitFor(t, "move to 1°", func() bool { return s.Angle() == 1 })
+	if v, _ := dir.Value(); v != 0 {
+		t.Errorf("inverted dir = %d moving forward, want 0", v)
+	}
+}
+
+func TestStepperRamp(t *testing.T) {
+	s, err := NewStepper(testStepperConfig(), &fakeLine{}, &fakeLine{}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.target = s.toSteps(90)
+	var delays []time.Duration
+	for {
+		dir, d := s.next()
+		if dir == 0 {
+			break
+		}
+		if dir != 1 {
+			t.Fatalf("step %d went %d, want 1", len(delays), dir)
+		}
+		delays = append(delays, d)
+	}
+	if len(delays) != 360 {
+		t.Fatalf("took %d steps to 90°, want 360", len(delays))
+	}
+	minDelay := time.Second / (600 * 4)
+	for i, d := range delays {
+		if d < minDelay-time.Microsecond {
+			t.Fatalf("step %d delay %v is faster than the %v top speed", i, d, minDelay)
+		}
+	}
+	if delays[0] <= delays[len(delays)/2] || delays[len(delays)-1] <= delays[len(delays)/2] {
+		t.Errorf("expected slow steps at both ends, got %v, %v, %v", delays[0], delays[len(delays)/2], delays[len(delays)-1])
+	}
+	for i := 1; i < 20; i++ {
+		if delays[i] > delays[i-1] {
+			t.Errorf("step %d slowed down while accelerating: %v after %v", i, delays[i], delays[i-1])
+		}
+	}
+}
+
+func TestStepperHome(t *testing.T) {
+	c := testStepperConfig()
+	c.HomeAngle = 15
+	c.HomeSpeed = 1000
+	step, dir, endstop := &fakeLine{}, &fakeLine{}, &fakeLine{}
+	step.onRise = func() {
+		if step.Rises() >= 25 {
+			endstop.SetValue(1)
+		}
+	}
+	s, err := NewStepper(c, step, dir, nil, endstop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Home(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := step.Rises(); got != 25 {
+		t.Errorf("stepped %d times before the endstop, want 25", got)
+	}
+	if v, _ := dir.Value(); v != 0 {
+		t.Errorf("dir = %d homing towards -1, want 0", v)
+	}
+	if got := s.Angle(); got != 15 {
+		t.Errorf("angle after homing = %v, want 15", got)
+	}
+}
+
+func TestStepperDriverFailedAxis(t *testing.T) {
+	next := NewSimServos(0)
+	homing := errors.New("endstop not reached")
+	d := NewStepperDriver(map[int]*Stepper{}, map[int]error{3: homing}, next)
+	defer d.Close()
+	if _, err := d.SetServoAngle(3, 45); !errors.Is(err, ErrNotReady) || !errors.Is(err, homing) {
+		t.Errorf("moving a failed axis returned %v, want ErrNotReady wrapping the homing error", err)
+	}
+	if _, err := d.SetXY(3, 4, 45, 45); err == nil {
+		t.Error("moving a failed axis with SetXY succeeded")
+	}
+	if x, _ := next.GetXY(3, 3); x != 90 {
+		t.Errorf("failed axis moved servo channel 3 to %v", x)
+	}
+	if _, ok := d.Faults()["stepper3"]; !ok {
+		t.Errorf("faults %v do not report the failed axis", d.Faults())
+	}
+}
+
+func TestStepperConfigDefaults(t *testing.T) {
+	var axes map[int]StepperConfig
+	err := json.Unmarshal([]byte(`{"1": {"stepLine": 5, "dirLine": 6, "stepsPerDegree": 0.5, "microsteps": 8}}`), &axes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := axes[1]
+	if err := c.Validate(); err != nil {
+		t.Errorf("minimal stepper config does not validate: %s", err)
+	}
+	if c.EnableLine != -1 || c.EndstopLine != -1 {
+		t.Errorf("unset enable and endstop lines are %d and %d, want -1", c.EnableLine, c.EndstopLine)
+	}
+	if c.StepsPerDegree != 0.5 || c.Microsteps != 8 {
+		t.Errorf("got %v steps per degree and %d microsteps, want the configured 0.5 and 8", c.StepsPerDegree, c.Microsteps)
+	}
+}
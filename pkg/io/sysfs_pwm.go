@@ -0,0 +1,143 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SysfsPWMRoot is where the kernel exposes its PWM chips.
+const SysfsPWMRoot = "/sys/class/pwm"
+
+// SysfsPWM drives the SoC's own PWM outputs through sysfs, for builds with
+// servos on PWM0/PWM1 and no PCA9685. It takes the same 12-bit on/off ticks
+// as the PCA9685, as a fraction of the period. Calibrations only carry over
+// when it runs at the frequency the PCA9685 would, which NewPCA9685 does.
+type SysfsPWM struct {
+	mu      sync.Mutex
+	dir     string // e.g. /sys/class/pwm/pwmchip0
+	period  time.Duration
+	enabled map[int]bool // exported channels and whether they are enabled
+}
+
+// NewSysfsPWM opens pwmchip chip under root at frequency Hz. Channels are
+// exported on first use.
+func NewSysfsPWM(root string, chip int, frequency float64) (*SysfsPWM, error) {
+	dir := filepath.Join(root, fmt.Sprintf("pwmchip%d", chip))
+	if _, err := os.Stat(dir); err != nil {
+		kind := ErrNotReady
+		if errors.Is(err, fs.ErrNotExist) {
+			kind = ErrPWMDisabled
+		} else if errors.Is(err, fs.ErrPermission) {
+			kind = ErrPermission
+		}
+		return nil, &HardwareError{Subsystem: SubsystemPWM, Kind: kind, Err: err}
+	}
+	if frequency <= 0 {
+		return nil, fmt.Errorf("sysfs pwm frequency must be positive, got %v", frequency)
+	}
+	return &SysfsPWM{
+		dir:     dir,
+		period:  time.Duration(float64(time.Second) / frequency),
+		enabled: make(map[int]bool),
+	}, nil
+}
+
+func (p *SysfsPWM) write(name string, value int64) error {
+	err := os.WriteFile(filepath.Join(p.dir, name), []byte(strconv.FormatInt(value, 10)), 0644)
+	if errors.Is(err, fs.ErrPermission) {
+		return &HardwareError{Subsystem: SubsystemPWM, Kind: ErrPermission, Err: err}
+	}
+	return err
+}
+
+// export makes a channel available and sets its period. Callers must hold
+// p.mu.
+func (p *SysfsPWM) export(channel int) error {
+	if _, ok := p.enabled[channel]; ok {
+		return nil
+	}
+	pwm := fmt.Sprintf("pwm%d", channel)
+	if _, err := os.Stat(filepath.Join(p.dir, pwm)); errors.Is(err, fs.ErrNotExist) {
+		if err := p.write("export", int64(channel)); err != nil {
+			return fmt.Errorf("failed exporting %s: %w", pwm, err)
+		}
+		// udev fixes up permissions of the new directory asynchronously.
+		deadline := time.Now().Add(time.Second)
+		for {
+			err := p.write(filepath.Join(pwm, "duty_cycle"), 0)
+			if err == nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s did not become writable: %w", pwm, err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	} else if err := p.write(filepath.Join(pwm, "duty_cycle"), 0); err != nil {
+		// The duty cycle must not exceed the new period.
+		return fmt.Errorf("failed clearing %s duty cycle: %w", pwm, err)
+	}
+	if err := p.write(filepath.Join(pwm, "period"), p.period.Nanoseconds()); err != nil {
+		return fmt.Errorf("failed setting %s period: %w", pwm, err)
+	}
+	p.enabled[channel] = false
+	return nil
+}
+
+// duty converts PCA9685 style on/off ticks, including the full-on and
+// full-off bits, to a duty cycle.
+func (p *SysfsPWM) duty(on, off uint16) time.Duration {
+	switch {
+	case off&0x1000 != 0:
+		return 0
+	case on&0x1000 != 0:
+		return p.period
+	}
+	ticks := (int64(off) - int64(on) + 4096) % 4096
+	return time.Duration(int64(p.period) * ticks / 4096)
+}
+
+func (p *SysfsPWM) SetPWM(channel int, on, off uint16) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.export(channel); err != nil {
+		return err
+	}
+	pwm := fmt.Sprintf("pwm%d", channel)
+	if err := p.write(filepath.Join(pwm, "duty_cycle"), p.duty(on, off).Nanoseconds()); err != nil {
+		return fmt.Errorf("failed setting %s duty cycle: %w", pwm, err)
+	}
+	if !p.enabled[channel] {
+		if err := p.write(filepath.Join(pwm, "enable"), 1); err != nil {
+			return fmt.Errorf("failed enabling %s: %w", pwm, err)
+		}
+		p.enabled[channel] = true
+	}
+	return nil
+}
+
+// Halt disables and unexports every channel that was used.
+func (p *SysfsPWM) Halt() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var errs []error
+	for channel := range p.enabled {
+		pwm := fmt.Sprintf("pwm%d", channel)
+		if err := p.write(filepath.Join(pwm, "enable"), 0); err != nil {
+			errs = append(errs, fmt.Errorf("failed disabling %s: %w", pwm, err))
+		}
+		if err := p.write("unexport", int64(channel)); err != nil {
+			errs = append(errs, fmt.Errorf("failed unexporting %s: %w", pwm, err))
+		}
+		delete(p.enabled, channel)
+	}
+	return errors.Join(errs...)
+}
+
+var _ PWMDriver = (*SysfsPWM)(nil)
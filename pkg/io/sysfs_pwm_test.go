@@ -0,0 +1,147 @@
+package io
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSysfs lays out a pwmchip directory like the kernel does. When
+// udev is set, exporting a channel creates its directory a little later,
+// the way udev does on a real system.
+func fakeSysfs(t *testing.T, udev bool) string {
+	t.Helper()
+	root := t.TempDir()
+	chip := filepath.Join(root, "pwmchip0")
+	if err := os.MkdirAll(chip, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"export", "unexport"} {
+		if err := os.WriteFile(filepath.Join(chip, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !udev {
+		return root
+	}
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			b, _ := os.ReadFile(filepath.Join(chip, "export"))
+			if len(b) > 0 {
+				_ = os.MkdirAll(filepath.Join(chip, "pwm"+string(b)), 0755)
+				return
+			}
+		}
+	}()
+	return root
+}
+
+func readSysfs(t *testing.T, root, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(root, "pwmchip0", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func TestSysfsPWMExport(t *testing.T) {
+	root := fakeSysfs(t, true)
+	p, err := NewSysfsPWM(root, 0, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetPWM(1, 0, 2048); err != nil {
+		t.Fatal(err)
+	}
+	if got := readSysfs(t, root, "export"); got != "1" {
+		t.Errorf("exported %q, want 1", got)
+	}
+	if got := readSysfs(t, root, "pwm1/period"); got != "20000000" {
+		t.Errorf("period = %s, want 20000000", got)
+	}
+	if got := readSysfs(t, root, "pwm1/duty_cycle"); got != "10000000" {
+		t.Errorf("duty_cycle = %s, want 10000000", got)
+	}
+	if got := readSysfs(t, root, "pwm1/enable"); got != "1" {
+		t.Errorf("enable = %s, want 1", got)
+	}
+
+	if err := p.Halt(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readSysfs(t, root, "pwm1/enable"); got != "0" {
+		t.Errorf("enable after Halt = %s, want 0", got)
+	}
+	if got := readSysfs(t, root, "unexport"); got != "1" {
+		t.Errorf("unexported %q, want 1", got)
+	}
+}
+
+func TestSysfsPWMDuty(t *testing.T) {
+	root := fakeSysfs(t, false)
+	if err := os.MkdirAll(filepath.Join(root, "pwmchip0", "pwm0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewSysfsPWM(root, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		on, off uint16
+		want    string
+	}{
+		{0, 1024, "2500000"},
+		{1024, 2048, "2500000"},
+		{3072, 1024, "5000000"},
+		{0, 0x1000, "0"},
+		{0x1000, 0, "10000000"},
+	} {
+		if err := p.SetPWM(0, c.on, c.off); err != nil {
+			t.Fatal(err)
+		}
+		if got := readSysfs(t, root, "pwm0/duty_cycle"); got != c.want {
+			t.Errorf("on %d off %d: duty_cycle = %s, want %s", c.on, c.off, got, c.want)
+		}
+	}
+	if got := readSysfs(t, root, "export"); got != "" {
+		t.Errorf("exported %q although pwm0 already existed", got)
+	}
+}
+
+func TestSysfsPWMMissingChip(t *testing.T) {
+	_, err := NewSysfsPWM(t.TempDir(), 0, 50)
+	if !errors.Is(err, ErrPWMDisabled) {
+		t.Errorf("opening a missing pwmchip returned %v, want ErrPWMDisabled", err)
+	}
+}
+
+// TestSysfsPWMMatchesPCA9685 checks that a calibration gives the same
+// pulse widths on the sysfs backend as on a PCA9685.
+func TestSysfsPWMMatchesPCA9685(t *testing.T) {
+	for _, f := range []float64{0, 50} {
+		cfg := PCA9685Config{Frequency: f}
+		p, err := NewSysfsPWM(fakeSysfs(t, false), 0, cfg.frequency())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ticks := DefaultCalibration().Ticks(0)
+		pca := time.Duration(float64(ticks) / 4096 / (nominalOscillatorHz / (4096 * (float64(cfg.prescale()) + 1))) * float64(time.Second))
+		if got := p.duty(0, ticks); (got - pca).Abs() > time.Microsecond {
+			t.Errorf("frequency %v: %d ticks are %v on sysfs and %v on a PCA9685", f, ticks, got, pca)
+		}
+	}
+	if got := (PCA9685Config{}).frequency(); got < 190 || got > 200 {
+		t.Errorf("default frequency %vHz, want the PCA9685 power-on rate of about 197Hz", got)
+	}
+}
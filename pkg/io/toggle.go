@@ -2,28 +2,43 @@ package io
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/warthog618/go-gpiocdev"
 )
 
 // SetPinState is a utility function to set a GPIO pin to a high or low state.
 // It takes the pin name as a string and the desired state (gpio.High or gpio.Low).
+// A line is requested at its inactive level, so it never glitches on.
 func (io *IO) SetPinState(pinName int, state int) error {
-	// Look up the GPIO pin by its name (e.g., "GPIO21").
-	var l *gpiocdev.Line
-	var err error
-	if v, ok := io.lines[pinName]; ok {
-		l = v
-	} else {
-		l, err = io.chip.RequestLine(pinName, gpiocdev.AsOutput(0))
-		if err != nil {
-			fmt.Printf("Opening chip returned error: %s\n", err)
-			os.Exit(1)
-		}
-		io.lines[pinName] = l
-
+	l, err := io.requestOutput(pinName)
+	if err != nil {
+		return err
 	}
 	// Set the pin's output state.
 	return l.SetValue(state)
 }
+
+// SetInactiveLevel records the level that switches a line off, such as 1
+// for an active low laser. The line is requested at that level and left
+// there by Close.
+func (io *IO) SetInactiveLevel(pinName int, level int) {
+	io.pinMu.Lock()
+	defer io.pinMu.Unlock()
+	io.inactive[pinName] = level
+}
+
+// requestOutput returns the output line at offset, requesting it at its
+// inactive level if needed.
+func (io *IO) requestOutput(offset int) (*gpiocdev.Line, error) {
+	io.pinMu.Lock()
+	defer io.pinMu.Unlock()
+	if l, ok := io.lines[offset]; ok {
+		return l, nil
+	}
+	l, err := io.chip.RequestLine(offset, gpiocdev.AsOutput(io.inactive[offset]))
+	if err != nil {
+		return nil, gpioError(fmt.Errorf("requesting line %d: %w", offset, err), ErrLineUnavailable)
+	}
+	io.lines[offset] = l
+	return l, nil
+}